@@ -8,10 +8,39 @@ import (
 
 // Args contains the command line arguments of application.
 type Args struct {
-	Command  string
-	WorkDir  string
-	MapFile  string
-	SkipDocs string
+	Command   string
+	WorkDir   string
+	MapFile   string
+	SkipDocs  string
+	Recursive string
+	By        string
+	Max       string
+	Level     string
+	Type      string
+	UUIDs     string
+	Class     string
+	Name      string
+	Deps      string
+	Format    string
+	Graphs    string
+	Dot       string
+	AsProcess string
+	Methods   string
+	Lib       string
+	Schema    string
+	Factors   string
+	Hierarchy string
+	Strategy  string
+	Weights   string
+	LocNames  string
+
+	// MapFileGiven is true if the mapping file was explicitly given with the
+	// `-mapfile` option and not just the default value is used
+	MapFileGiven bool
+
+	// Files contains the values that are not options, e.g. the packages of
+	// the diff command
+	Files []string
 }
 
 // ReadArgs reads the command line arguments.
@@ -36,6 +65,7 @@ func ReadArgs() *Args {
 			continue
 		}
 		if flag == "" {
+			args.Files = append(args.Files, val)
 			continue
 		}
 		switch flag {
@@ -43,11 +73,65 @@ func ReadArgs() *Args {
 			args.WorkDir = val
 		case "-mapfile":
 			args.MapFile = val
+			args.MapFileGiven = true
 		case "-skipdocs":
 			args.SkipDocs = val
+		case "-recursive":
+			args.Recursive = val
+		case "-by":
+			args.By = val
+		case "-max":
+			args.Max = val
+		case "-level":
+			args.Level = val
+		case "-type":
+			args.Type = val
+		case "-uuids":
+			args.UUIDs = val
+		case "-class":
+			args.Class = val
+		case "-name":
+			args.Name = val
+		case "-deps":
+			args.Deps = val
+		case "-format":
+			args.Format = val
+		case "-graphs":
+			args.Graphs = val
+		case "-dot":
+			args.Dot = val
+		case "-asprocess":
+			args.AsProcess = val
+		case "-methods":
+			args.Methods = val
+		case "-lib":
+			args.Lib = val
+		case "-schema":
+			args.Schema = val
+		case "-factors":
+			args.Factors = val
+		case "-hierarchy":
+			args.Hierarchy = val
+		case "-strategy":
+			args.Strategy = val
+		case "-weights":
+			args.Weights = val
+		case "-locnames":
+			args.LocNames = val
 		}
 		flag = ""
 	}
 
 	return &args
 }
+
+// IsRecursive returns true if the packages in the sub-folders of the working
+// directory should be processed too.
+func (args *Args) IsRecursive() bool {
+	return isTrue(args.Recursive)
+}
+
+// isTrue returns true if the given flag value is `true` or `1`.
+func isTrue(val string) bool {
+	return val == "true" || val == "1"
+}
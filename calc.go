@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// ModelResult contains the result of a life cycle model calculation.
+type ModelResult struct {
+	Model *ilcd.Model
+
+	// the reference flow of the reference process
+	RefFlow *FlowAmount
+
+	// internal ID -> scaling factor
+	Scaling map[int]float64
+
+	// the aggregated elementary flows, sorted by their keys (see
+	// `FlowAmount.Key`)
+	Inventory []*FlowAmount
+}
+
+// CalcModel calculates the life cycle inventory of the given model: the
+// scaled elementary flows of all processes are aggregated by flow, direction,
+// and location. Problems of the model are logged as warnings.
+func CalcModel(model *ilcd.Model, reader *ilcd.ZipReader, flows *FlowIndex) (*ModelResult, error) {
+	subject := (&Report{}).NewSubject("", ilcd.ModelDataSet.Folder(),
+		model.UUID(), model.FullName("en"))
+	info := checkModel(model, reader, nil, subject)
+	for _, f := range subject.Findings {
+		if f.Severity == SeverityError {
+			log.Println(" ... WARNING:", f.Message)
+		}
+	}
+	sys := newModelSystem(info)
+	if sys == nil {
+		return nil, errors.New("the reference process of the model is missing")
+	}
+	solution, err := sys.solve()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ModelResult{Model: model, Scaling: make(map[int]float64)}
+	for _, id := range sys.ids {
+		result.Scaling[id] = sys.scaling(solution, id)
+	}
+
+	// the linked exchanges are not part of the inventory
+	linked := make(map[string]bool)
+	for _, link := range sys.links {
+		linked[inputKey(link.provider, link.outputFlow)+"/output"] = true
+		linked[inputKey(link.recipient, link.inputFlow)+"/input"] = true
+	}
+
+	sums := make(map[string]*FlowAmount)
+	ignored := 0
+	refFlow := info.refFlows[sys.refID]
+	for _, id := range sys.ids {
+		s := result.Scaling[id]
+		for _, e := range info.exchanges[id] {
+			if id == sys.refID && e.InternalID == refFlow {
+				r := *e
+				result.RefFlow = &r
+				continue
+			}
+			if linked[inputKey(id, e.FlowID)+"/"+NormKey(e.Direction)] {
+				continue
+			}
+			flow := flows.Get(e.FlowID)
+			if flow == nil || !flow.IsElementary() {
+				ignored++
+				continue
+			}
+			key := e.Key()
+			sum := sums[key]
+			if sum == nil {
+				sum = &FlowAmount{
+					FlowID:    e.FlowID,
+					FlowName:  flow.Name,
+					Direction: e.Direction,
+					Location:  e.Location}
+				sums[key] = sum
+			}
+			sum.Amount += s * e.Amount
+		}
+	}
+	if ignored > 0 {
+		log.Println(" ... ignored", ignored,
+			"exchanges of unlinked product flows or of unknown flows")
+	}
+
+	keys := make([]string, 0, len(sums))
+	for key := range sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Inventory = append(result.Inventory, sums[key])
+	}
+	return result, nil
+}
+
+// calcModels calculates the life cycle models in the packages of the working
+// directory and writes their inventories into CSV files.
+func calcModels(args *Args) {
+	var uuids map[string]bool
+	if args.UUIDs != "" {
+		uuids = readUUIDs(args.UUIDs)
+	}
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		flows := NewFlowIndex(reader.ZipReader)
+		var results []*ModelResult
+		reader.EachModel(func(model *ilcd.Model) bool {
+			if uuids != nil && !uuids[NormKey(model.UUID())] {
+				return true
+			}
+			log.Println("INFO: calculate model", model.FullName("en"), model.UUID())
+			result, err := CalcModel(model, reader.ZipReader, flows)
+			if err != nil {
+				log.Println("ERROR: Failed to calculate model", model.UUID(), err)
+				return true
+			}
+			writeInventory(result, flows,
+				filepath.Join(args.WorkDir, "peflocus_lci_"+model.UUID()+".csv"))
+			results = append(results, result)
+			return true
+		})
+		if isTrue(args.AsProcess) && len(results) > 0 {
+			writeResultProcesses(results, reader.ZipReader, flows,
+				OutputPath(args.WorkDir, name, "peflocus_lci_"))
+		}
+		reader.Close()
+	}
+}
+
+func writeInventory(result *ModelResult, flows *FlowIndex, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Println("ERROR: Failed to create file", path, err)
+		return
+	}
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	writer.Write([]string{"Flow UUID", "Flow", "Direction", "Location",
+		"Amount", "Unit"})
+	for _, a := range result.Inventory {
+		unit := ""
+		if flow := flows.Get(a.FlowID); flow != nil {
+			unit = flow.Unit
+		}
+		writer.Write([]string{a.FlowID, a.FlowName, a.Direction, a.Location,
+			strconv.FormatFloat(a.Amount, 'g', -1, 64), unit})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("ERROR: Failed to write inventory", path, err)
+		return
+	}
+	log.Println(" ... wrote", len(result.Inventory), "flows to", path)
+}
+
+// writeResultProcesses writes the given model results as ILCD process data
+// sets of type `LCI result` together with the used flows and their
+// dependencies into a new package.
+func writeResultProcesses(results []*ModelResult, reader *ilcd.ZipReader,
+	flows *FlowIndex, path string) {
+	DeleteExisting(path)
+	writer, err := ilcd.NewZipWriter(path)
+	if err != nil {
+		log.Println("ERROR: Failed to create zip writer for", path, ":", err)
+		return
+	}
+	defer writer.Close()
+
+	var flowFiles []*ilcd.ZipFile
+	added := make(map[string]bool)
+	addFlow := func(uuid string) {
+		if added[uuid] {
+			return
+		}
+		added[uuid] = true
+		if zipFile := reader.FindDataSet(ilcd.FlowDataSet, uuid); zipFile != nil {
+			flowFiles = append(flowFiles, zipFile)
+		}
+	}
+
+	for _, result := range results {
+		uuid := NameUUID("lci/" + result.Model.UUID())
+		data, err := resultProcess(uuid, result)
+		if err != nil {
+			log.Println("ERROR: Failed to create LCI result of model",
+				result.Model.UUID(), err)
+			continue
+		}
+		entry := "ILCD/processes/" + uuid + ".xml"
+		if err := writer.Write(entry, data); err != nil {
+			log.Println("ERROR: Failed to write", entry, err)
+			continue
+		}
+		log.Println("INFO: wrote LCI result", entry)
+		if result.RefFlow != nil {
+			addFlow(result.RefFlow.FlowID)
+		}
+		for _, a := range result.Inventory {
+			addFlow(a.FlowID)
+		}
+	}
+
+	for _, zipFile := range NewDependencies(reader).Closure(flowFiles...) {
+		data, err := zipFile.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read", zipFile.Path(), err)
+			continue
+		}
+		if err := writer.Write(zipFile.Path(), data); err != nil {
+			log.Println("ERROR: Failed to write", zipFile.Path(), err)
+		}
+	}
+}
+
+// resultProcess creates a process data set of type `LCI result` with the
+// reference flow and the inventory of the given model result.
+func resultProcess(uuid string, result *ModelResult) ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	root := doc.CreateElement("processDataSet")
+	root.CreateAttr("xmlns", "http://lca.jrc.it/ILCD/Process")
+	root.CreateAttr("xmlns:common", "http://lca.jrc.it/ILCD/Common")
+	root.CreateAttr("version", "1.1")
+
+	procInfo := root.CreateElement("processInformation")
+	dataInfo := procInfo.CreateElement("dataSetInformation")
+	dataInfo.CreateElement("common:UUID").SetText(uuid)
+	baseName := dataInfo.CreateElement("name").CreateElement("baseName")
+	baseName.CreateAttr("xml:lang", "en")
+	baseName.SetText("LCI result of " + result.Model.FullName("en"))
+	qRef := procInfo.CreateElement("quantitativeReference")
+	qRef.CreateAttr("type", "Reference flow(s)")
+	qRef.CreateElement("referenceToReferenceFlow").SetText("0")
+
+	root.CreateElement("modellingAndValidation").
+		CreateElement("LCIMethodAndAllocation").
+		CreateElement("typeOfDataSet").SetText("LCI result")
+	root.CreateElement("administrativeInformation").
+		CreateElement("publicationAndOwnership").
+		CreateElement("common:dataSetVersion").SetText("01.00.000")
+
+	exchanges := root.CreateElement("exchanges")
+	if result.RefFlow != nil {
+		addExchange(exchanges, 0, result.RefFlow)
+	}
+	for i, a := range result.Inventory {
+		addExchange(exchanges, i+1, a)
+	}
+	doc.Indent(2)
+	return doc.WriteToBytes()
+}
+
+func addExchange(parent *etree.Element, internalID int, a *FlowAmount) {
+	e := parent.CreateElement("exchange")
+	e.CreateAttr("dataSetInternalID", strconv.Itoa(internalID))
+	ref := e.CreateElement("referenceToFlowDataSet")
+	ref.CreateAttr("type", "flow data set")
+	ref.CreateAttr("refObjectId", a.FlowID)
+	ref.CreateAttr("uri", "../flows/"+a.FlowID+".xml")
+	if a.FlowName != "" {
+		name := ref.CreateElement("common:shortDescription")
+		name.CreateAttr("xml:lang", "en")
+		name.SetText(a.FlowName)
+	}
+	if a.Location != "" {
+		e.CreateElement("location").SetText(a.Location)
+	}
+	direction := "Output"
+	if strings.EqualFold(a.Direction, "Input") {
+		direction = "Input"
+	}
+	e.CreateElement("exchangeDirection").SetText(direction)
+	amount := strconv.FormatFloat(a.Amount, 'g', -1, 64)
+	e.CreateElement("meanAmount").SetText(amount)
+	e.CreateElement("resultingAmount").SetText(amount)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// convertPackage applies the flow mapping of the given generator on the
+// package with the given source path and writes the result into a new
+// package with the given target path; depending on the generator, the flows
+// are mapped or unmapped. The data sets are converted as streams from the zip
+// entries of the source package into the entries of the target package; the
+// generated flows and the used flows that were not (un-)mapped are added, and
+// all other entries are copied as they are. The reader, writer, folder, and
+// statistics of the generator are set by this function. It returns false if
+// the source package could not be read.
+func convertPackage(sourcePath, targetPath string, gen *FlowGenerator) bool {
+
+	// create the reader and writer
+	reader, err := OpenPackage(sourcePath)
+	if err != nil {
+		log.Println("ERROR: Failed to read package", sourcePath, ":", err)
+		return false
+	}
+	defer reader.Close()
+	source, err := zip.OpenReader(reader.ZipPath())
+	if err != nil {
+		log.Println("ERROR: Failed to open zip file", reader.ZipPath(), err)
+		return false
+	}
+	defer source.Close()
+	writer, closeFn := createZip(targetPath)
+	defer closeFn()
+
+	convert := gen.flowMap.UnmapFlows
+	if gen.forMapped {
+		convert = gen.flowMap.MapFlows
+		gen.flowMap.IndexLocations(reader.ZipReader)
+	}
+
+	// convert the flows in the data sets and copy all other entries
+	stats := NewEntryStats()
+	flowFolder := ""
+	for _, f := range source.File {
+		path := f.Name
+		if flowFolder == "" && ilcd.IsFlowPath(path) {
+			flowFolder = strings.Split(path, "flows")[0] + "flows/"
+		}
+		if strings.HasSuffix(path, "/") {
+			stats.Skipped(path)
+			continue
+		}
+		if GetPathType(path) == ilcd.FlowDataSet {
+			continue // flows are filtered & written later
+		}
+		if !IsDataSetEntry(path) {
+			// external documents, assets etc. are copied as they are
+			if err := copyEntry(writer, f, path); err != nil {
+				log.Println("ERROR: Failed to copy entry", path, err)
+				stats.Skipped(path)
+				continue
+			}
+			stats.Copied(path)
+			continue
+		}
+		changed, err := mapEntry(writer, f, convert)
+		if err != nil {
+			log.Println("ERROR: Failed to convert flows in", path, err)
+		}
+		if changed {
+			stats.Transformed(path)
+		} else {
+			stats.Copied(path)
+		}
+	}
+
+	gen.folder = flowFolder
+	gen.reader = reader.ZipReader
+	gen.writer = writer
+	gen.stats = stats
+	gen.Generate()
+
+	// copy the flows that were not mapped but are used
+	log.Println("INFO: Copy untouched but used flows")
+	count := copyUsedFlows(source, writer, flowFolder, gen.flowMap, stats)
+	log.Println(" ... copied", count, "flows")
+	stats.Log()
+	return true
+}
+
+// mapEntry applies the given (un-)mapping function on the data set of the
+// given zip entry. The data set is streamed from the entry into a new entry
+// with the same path in the given writer. It returns true if the data set was
+// changed.
+func mapEntry(writer *zip.Writer, f *zip.File,
+	fn func(path string, r io.Reader, w io.Writer) (bool, error)) (bool, error) {
+	r, err := f.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	w, err := createEntry(writer, f.Name, f.Modified)
+	if err != nil {
+		return false, err
+	}
+	return fn(f.Name, r, w)
+}
+
+// copyUsedFlows copies the flows of the given package that are used but were
+// not (un-)mapped into the given flow folder of the target package. The flows
+// are written with the name pattern `<uuid>_<version>.xml`. It returns the
+// number of copied flows.
+func copyUsedFlows(source *zip.ReadCloser, writer *zip.Writer, folder string,
+	flowMap *FlowMap, stats *EntryStats) int {
+	count := 0
+	replaced := flowMap.replacedFlows()
+	for _, f := range source.File {
+		if strings.HasSuffix(f.Name, "/") || GetPathType(f.Name) != ilcd.FlowDataSet {
+			continue
+		}
+		doc := etree.NewDocument()
+		if err := readEntryDoc(f, doc); err != nil {
+			log.Println("ERROR: Failed to read flow", f.Name, err)
+			stats.Skipped(f.Name)
+			continue
+		}
+		uuid := DataSetUUID(doc)
+		if !flowMap.untouchedUsed[uuid] {
+			// mapped flows are replaced by the generated flows
+			if replaced[NormKey(uuid)] {
+				stats.Skipped(f.Name)
+			} else {
+				stats.Dropped(f.Name)
+			}
+			continue
+		}
+		path := folder + uuid + "_" + DataSetVersion(doc) + ".xml"
+		if err := copyEntry(writer, f, path); err != nil {
+			log.Println("ERROR: Failed to copy flow", f.Name, err)
+			stats.Skipped(f.Name)
+			continue
+		}
+		count++
+		// the flow is written with a new name
+		stats.Transformed(f.Name)
+	}
+	return count
+}
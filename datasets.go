@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// ParseDataSetType returns the data set type for the given name. The name
+// can be the folder name of the type (e.g. `processes`) or a short name like
+// `process` or `method`. It returns -1 if the name is not a known type.
+func ParseDataSetType(name string) ilcd.DataSetType {
+	n := strings.ToLower(strings.TrimSpace(name))
+	for _, t := range ilcd.DataSetTypes() {
+		if n == t.Folder() {
+			return t
+		}
+	}
+	switch n {
+	case "contact":
+		return ilcd.ContactDataSet
+	case "source":
+		return ilcd.SourceDataSet
+	case "unitgroup":
+		return ilcd.UnitGroupDataSet
+	case "flowproperty":
+		return ilcd.FlowPropertyDataSet
+	case "flow":
+		return ilcd.FlowDataSet
+	case "process":
+		return ilcd.ProcessDataSet
+	case "method", "lciamethod":
+		return ilcd.MethodDataSet
+	case "model", "lifecyclemodel":
+		return ilcd.ModelDataSet
+	case "doc", "external_doc":
+		return ilcd.ExternalDoc
+	}
+	return -1
+}
+
+// DataSetName returns the name of the given data set. For data sets with a
+// structured name (processes, flows, models) the base name is returned.
+func DataSetName(doc *etree.Document) string {
+	for _, path := range []string{
+		"//dataSetInformation/name/baseName",
+		"//dataSetInformation/name",
+		"//dataSetInformation/shortName"} {
+		if elem := doc.FindElement(path); elem != nil {
+			if name := strings.TrimSpace(elem.Text()); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// DataSetUUID returns the UUID of the given data set.
+func DataSetUUID(doc *etree.Document) string {
+	if elem := doc.FindElement("//dataSetInformation/UUID"); elem != nil {
+		return strings.TrimSpace(elem.Text())
+	}
+	return ""
+}
+
+// Classification returns the class path of the first classification of the
+// given data set. For elementary flows, the categories of the elementary
+// flow categorization are returned.
+func Classification(data []byte) []string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil
+	}
+	return ClassificationOf(doc)
+}
+
+// ClassificationOf returns the class path of the first classification of the
+// given data set document (see `Classification`).
+func ClassificationOf(doc *etree.Document) []string {
+	info := doc.FindElement("//dataSetInformation/classificationInformation")
+	if info == nil {
+		return nil
+	}
+	var path []string
+	if classification := info.FindElement("./classification"); classification != nil {
+		for _, class := range classification.SelectElements("class") {
+			path = append(path, strings.TrimSpace(class.Text()))
+		}
+		return path
+	}
+	if categorization := info.FindElement("./elementaryFlowCategorization"); categorization != nil {
+		for _, category := range categorization.SelectElements("category") {
+			path = append(path, strings.TrimSpace(category.Text()))
+		}
+	}
+	return path
+}
+
+// DataSetVersion returns the version of the given data set.
+func DataSetVersion(doc *etree.Document) string {
+	path := "//administrativeInformation/publicationAndOwnership/dataSetVersion"
+	if elem := doc.FindElement(path); elem != nil {
+		return strings.TrimSpace(elem.Text())
+	}
+	return ""
+}
+
+// ReferenceFlowID returns the data set internal ID of the (first) reference
+// flow of the given process data set.
+func ReferenceFlowID(doc *etree.Document) string {
+	path := "./processDataSet/processInformation/quantitativeReference/referenceToReferenceFlow"
+	if elem := doc.FindElement(path); elem != nil {
+		return strings.TrimSpace(elem.Text())
+	}
+	return ""
+}
+
+// ReferenceFlowProperty returns the UUID of the reference flow property of
+// the given flow data set.
+func ReferenceFlowProperty(doc *etree.Document) string {
+	path := "./flowDataSet/flowInformation/quantitativeReference/referenceToReferenceFlowProperty"
+	elem := doc.FindElement(path)
+	if elem == nil {
+		return ""
+	}
+	refID := strings.TrimSpace(elem.Text())
+	for _, prop := range doc.FindElements("./flowDataSet/flowProperties/flowProperty") {
+		if strings.TrimSpace(prop.SelectAttrValue("dataSetInternalID", "")) != refID {
+			continue
+		}
+		if ref := prop.FindElement("./referenceToFlowPropertyDataSet"); ref != nil {
+			return strings.TrimSpace(ref.SelectAttrValue("refObjectId", ""))
+		}
+	}
+	return ""
+}
+
+// ReferenceUnit returns the name of the reference unit of the given unit
+// group data set. It returns an empty string if the reference unit does not
+// exist.
+func ReferenceUnit(doc *etree.Document) string {
+	elem := doc.FindElement(
+		"./unitGroupDataSet/unitGroupInformation/quantitativeReference/referenceToReferenceUnit")
+	if elem == nil {
+		return ""
+	}
+	refID := strings.TrimSpace(elem.Text())
+	for _, unit := range doc.FindElements("./unitGroupDataSet/units/unit") {
+		if strings.TrimSpace(unit.SelectAttrValue("dataSetInternalID", "")) == refID {
+			return childText(unit, "./name")
+		}
+	}
+	return ""
+}
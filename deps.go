@@ -0,0 +1,203 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// Dependencies collects the data sets and external documents that are
+// referenced by data sets of a package, directly or indirectly.
+type Dependencies struct {
+	reader *ilcd.ZipReader
+
+	// external document name -> zip entry
+	docs map[string]*ilcd.ZipFile
+
+	// zip entry path -> the direct dependencies of that entry
+	direct map[string][]*ilcd.ZipFile
+
+	// data set type and UUID -> zip entry; see `dataSetKey`
+	dataSets map[string]*ilcd.ZipFile
+
+	// true if there are data sets with file names that do not start with
+	// their UUID; these can be only found by searching the package
+	unindexed bool
+}
+
+// NewDependencies creates a new dependency collector for the given package.
+func NewDependencies(reader *ilcd.ZipReader) *Dependencies {
+	d := &Dependencies{
+		reader:   reader,
+		docs:     make(map[string]*ilcd.ZipFile),
+		direct:   make(map[string][]*ilcd.ZipFile),
+		dataSets: make(map[string]*ilcd.ZipFile)}
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		if strings.HasSuffix(zipFile.Path(), "/") {
+			return true
+		}
+		t := zipFile.Type()
+		switch t {
+		case ilcd.ExternalDoc:
+			if name := ExternalDocName(zipFile.Path()); name != "" {
+				d.docs[name] = zipFile
+			}
+		case ilcd.Asset:
+		default:
+			uuid := fileUUID(zipFile.Path())
+			if uuid == "" {
+				d.unindexed = true
+				return true
+			}
+			key := dataSetKey(t, uuid)
+			if d.dataSets[key] == nil {
+				d.dataSets[key] = zipFile
+			}
+		}
+		return true
+	})
+	return d
+}
+
+// dataSetKey returns the index key of the data set with the given type and
+// UUID.
+func dataSetKey(t ilcd.DataSetType, uuid string) string {
+	return t.Folder() + "/" + strings.ToLower(uuid)
+}
+
+// fileUUID returns the UUID from the file name of the given data set path,
+// e.g. `processes/<uuid>_01.00.000.xml`. It returns an empty string if the
+// file name does not start with a UUID.
+func fileUUID(filePath string) string {
+	name := path.Base(strings.ReplaceAll(filePath, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if i := strings.Index(name, "_"); i >= 0 {
+		name = name[:i]
+	}
+	if !uuidPattern.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
+// find returns the data set with the given type and UUID from the package
+// or nil if there is no such data set.
+func (d *Dependencies) find(t ilcd.DataSetType, uuid string) *ilcd.ZipFile {
+	if zipFile := d.dataSets[dataSetKey(t, uuid)]; zipFile != nil {
+		return zipFile
+	}
+	if d.unindexed {
+		return d.reader.FindDataSet(t, uuid)
+	}
+	return nil
+}
+
+// Closure returns the given zip entries together with all data sets and
+// external documents that they reference directly or indirectly.
+func (d *Dependencies) Closure(files ...*ilcd.ZipFile) []*ilcd.ZipFile {
+	visited := make(map[string]bool)
+	var closure []*ilcd.ZipFile
+	queue := append([]*ilcd.ZipFile{}, files...)
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if visited[file.Path()] {
+			continue
+		}
+		visited[file.Path()] = true
+		closure = append(closure, file)
+		queue = append(queue, d.Direct(file)...)
+	}
+	return closure
+}
+
+// Direct returns the data sets and external documents that are directly
+// referenced by the given zip entry.
+func (d *Dependencies) Direct(file *ilcd.ZipFile) []*ilcd.ZipFile {
+	if deps, ok := d.direct[file.Path()]; ok {
+		return deps
+	}
+	var deps []*ilcd.ZipFile
+	t := file.Type()
+	if t != ilcd.ExternalDoc && t != ilcd.Asset {
+		deps = d.collect(file)
+	}
+	d.direct[file.Path()] = deps
+	return deps
+}
+
+func (d *Dependencies) collect(file *ilcd.ZipFile) []*ilcd.ZipFile {
+	data, err := file.Read()
+	if err != nil {
+		log.Println("ERROR: Failed to read entry", file.Path(), err)
+		return nil
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		log.Println("ERROR: Failed to parse entry", file.Path(), err)
+		return nil
+	}
+
+	var deps []*ilcd.ZipFile
+	added := make(map[string]bool)
+	add := func(dep *ilcd.ZipFile) {
+		if dep == nil || dep.Path() == file.Path() || added[dep.Path()] {
+			return
+		}
+		added[dep.Path()] = true
+		deps = append(deps, dep)
+	}
+
+	for _, ref := range doc.FindElements("//*[@refObjectId]") {
+		t := RefType(ref)
+		if t < 0 || t == ilcd.ExternalDoc {
+			continue
+		}
+		id := strings.TrimSpace(ref.SelectAttrValue("refObjectId", ""))
+		if id == "" {
+			continue
+		}
+		add(d.find(t, id))
+	}
+	for _, ref := range doc.FindElements("//referenceToDigitalFile[@uri]") {
+		name := ExternalDocName(ref.SelectAttrValue("uri", ""))
+		add(d.docs[name])
+	}
+	return deps
+}
+
+// RefType returns the data set type of the given data set reference, which
+// is an element with a `refObjectId` attribute. The type is taken from the
+// `type` attribute or, if this is not possible, from the `uri` attribute of
+// the reference. It returns -1 if the type could not be determined.
+func RefType(ref *etree.Element) ilcd.DataSetType {
+	switch strings.ToLower(strings.TrimSpace(ref.SelectAttrValue("type", ""))) {
+	case "contact data set":
+		return ilcd.ContactDataSet
+	case "source data set":
+		return ilcd.SourceDataSet
+	case "unit group data set":
+		return ilcd.UnitGroupDataSet
+	case "flow property data set":
+		return ilcd.FlowPropertyDataSet
+	case "flow data set":
+		return ilcd.FlowDataSet
+	case "process data set":
+		return ilcd.ProcessDataSet
+	case "lcia method data set":
+		return ilcd.MethodDataSet
+	case "lifecyclemodel data set", "life cycle model data set":
+		return ilcd.ModelDataSet
+	}
+	uri := ref.SelectAttrValue("uri", "")
+	if uri == "" {
+		return -1
+	}
+	if !strings.HasSuffix(strings.ToLower(uri), ".xml") {
+		uri += ".xml"
+	}
+	return GetPathType(uri)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// The strategies for collapsing regionalized factors.
+const (
+	// strategyGLO uses the unregionalized factor or, if there is no such
+	// factor, the factor for `GLO`
+	strategyGLO = "glo"
+
+	// strategyMean uses the arithmetic mean of all factors of a flow
+	strategyMean = "mean"
+
+	// strategyWeighted uses the mean of the factors weighted by the weights of
+	// their locations
+	strategyWeighted = "weighted"
+)
+
+// deregionalize collapses the regionalized characterization factors of the
+// LCIA methods in the packages of the working directory into one factor per
+// flow and direction. For each package, a copy `peflocus_dereg_<package>.zip`
+// with the collapsed methods is created. Flows for which no factor can be
+// calculated with the strategy keep their factors and are written to a report
+// `peflocus_dereg_<package>_skipped.csv`.
+func deregionalize(args *Args) {
+	strategy := strings.ToLower(strings.TrimSpace(args.Strategy))
+	if strategy == "" {
+		strategy = strategyGLO
+	}
+	var weights map[string]float64
+	switch strategy {
+	case strategyGLO, strategyMean:
+	case strategyWeighted:
+		if args.Weights == "" {
+			log.Fatalln("ERROR: No weights file given (-weights)")
+		}
+		weights = readLocationWeights(args.Weights)
+	default:
+		log.Fatalln("ERROR: Unknown strategy", args.Strategy)
+	}
+	var methodIDs map[string]bool
+	if args.Methods != "" {
+		methodIDs = readUUIDs(args.Methods)
+	}
+
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		log.Println("INFO: Deregionalize LCIA methods in", path, "using strategy",
+			strategy)
+		d := &deregionalizer{strategy: strategy, weights: weights}
+		replaced := make(map[string][]byte)
+		reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+			if zipFile.Type() != ilcd.MethodDataSet ||
+				!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+				return true
+			}
+			data, err := zipFile.Read()
+			if err != nil {
+				log.Println("ERROR: Failed to read LCIA method", zipFile.Path(), err)
+				return true
+			}
+			doc := etree.NewDocument()
+			if err := doc.ReadFromBytes(data); err != nil {
+				log.Println("ERROR: Failed to parse LCIA method", zipFile.Path(), err)
+				return true
+			}
+			if methodIDs != nil && !methodIDs[NormKey(DataSetUUID(doc))] {
+				return true
+			}
+			if !d.collapse(doc) {
+				return true
+			}
+			if data, err = doc.WriteToBytes(); err != nil {
+				log.Println("ERROR: Failed to write LCIA method", zipFile.Path(), err)
+				return true
+			}
+			replaced[zipFile.Path()] = data
+			return true
+		})
+		target := OutputPath(args.WorkDir, name, "peflocus_dereg_")
+		if len(d.skipped) > 0 {
+			d.writeReport(strings.TrimSuffix(target, ".zip") + "_skipped.csv")
+		}
+		if len(replaced) == 0 {
+			log.Println(" ... no regionalized factors were collapsed")
+			reader.Close()
+			continue
+		}
+		log.Println(" ... deregionalized", len(replaced), "LCIA methods")
+		copyPackageWith(reader.ZipReader, target, replaced)
+		reader.Close()
+	}
+}
+
+// writeReport writes the flows for which no factor could be calculated with
+// the strategy into a CSV file.
+func (d *deregionalizer) writeReport(path string) {
+	log.Println(" ... skipped", len(d.skipped), "flows without a factor for",
+		"the strategy", d.strategy)
+	f, err := os.Create(path)
+	if err != nil {
+		log.Println("ERROR: Failed to create file", path, err)
+		return
+	}
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	writer.Write([]string{"Method UUID", "Method", "Flow UUID", "Direction",
+		"Locations"})
+	for _, s := range d.skipped {
+		var locations []string
+		for _, factor := range s.group.regionalFactors() {
+			locations = append(locations, factor.Location)
+		}
+		writer.Write([]string{s.methodID, s.methodName, s.group.flowID,
+			s.group.direction, strings.Join(locations, " ")})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("ERROR: Failed to write report", path, err)
+		return
+	}
+	log.Println(" ... wrote report", path)
+}
+
+// readLocationWeights reads the weights of the locations from the given CSV
+// file with the columns: location code, weight. The first row is ignored.
+func readLocationWeights(file string) map[string]float64 {
+	log.Println("INFO: Read location weights from", file)
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read weights file", file, err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read weights file", file, err)
+	}
+	weights := make(map[string]float64)
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+		if len(row) < 2 {
+			log.Println("WARNING: invalid weight in row", i)
+			continue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil || weight < 0 {
+			log.Println("WARNING: invalid weight", row[1], "in row", i)
+			continue
+		}
+		if !Locations().Contains(row[0]) {
+			log.Println("WARNING: unknown location code", row[0], "in row", i)
+		}
+		weights[NormKey(row[0])] = weight
+	}
+	log.Println(" ... read", len(weights), "weights")
+	return weights
+}
+
+// deregionalizer collapses the factors of LCIA methods.
+type deregionalizer struct {
+	strategy string
+
+	// normalized location code -> weight
+	weights map[string]float64
+
+	// the flows of the current package for which no factor could be
+	// calculated with the strategy
+	skipped []*skippedFactors
+}
+
+// skippedFactors describes the factors of a flow and direction in an LCIA
+// method that were not collapsed.
+type skippedFactors struct {
+	methodID   string
+	methodName string
+	group      *factorGroup
+}
+
+// factorGroup contains the factors of a flow and direction of an LCIA method.
+type factorGroup struct {
+	flowID    string
+	direction string
+	elements  []*etree.Element
+	factors   []*FlowAmount
+}
+
+// collapse replaces the factors of the given LCIA method with one factor per
+// flow and direction. It returns false if the method has no regionalized
+// factors that could be collapsed and thus was not changed.
+func (d *deregionalizer) collapse(doc *etree.Document) bool {
+	container := doc.FindElement("./LCIAMethodDataSet/characterisationFactors")
+	if container == nil {
+		return false
+	}
+	var groups []*factorGroup
+	index := make(map[string]*factorGroup)
+	regionalized := false
+	for _, e := range container.SelectElements("factor") {
+		f := readFlowAmount(e)
+		if f == nil {
+			continue
+		}
+		readNumber(e, "./meanValue", &f.Amount)
+		if f.Location != "" {
+			regionalized = true
+		}
+		key := factorKey(f.FlowID, f.Direction)
+		group := index[key]
+		if group == nil {
+			group = &factorGroup{flowID: f.FlowID, direction: f.Direction}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.elements = append(group.elements, e)
+		group.factors = append(group.factors, f)
+	}
+	if !regionalized {
+		return false
+	}
+
+	// the first factor of a group is kept without location, the others are
+	// removed; the factors of groups without a value are kept unchanged
+	collapsed, skipped := 0, 0
+	for _, group := range groups {
+		if !group.regionalized() {
+			continue
+		}
+		value, ok := d.value(group)
+		if !ok {
+			log.Println(" ... WARNING: no factor for", group.flowID,
+				"("+group.direction+") with strategy", d.strategy+";",
+				"the factors are not changed")
+			d.skipped = append(d.skipped, &skippedFactors{
+				methodID:   DataSetUUID(doc),
+				methodName: DataSetName(doc),
+				group:      group})
+			skipped++
+			continue
+		}
+		first := group.elements[0]
+		if loc := first.SelectElement("location"); loc != nil {
+			removeIndented(first, loc)
+		}
+		meanValue := first.SelectElement("meanValue")
+		if meanValue == nil {
+			meanValue = etree.NewElement("meanValue")
+			appendIndented(first, meanValue)
+		}
+		meanValue.SetText(strconv.FormatFloat(value, 'g', -1, 64))
+		for _, e := range group.elements[1:] {
+			removeIndented(container, e)
+		}
+		collapsed++
+	}
+	if collapsed == 0 {
+		return false
+	}
+	log.Println(" ... collapsed the factors of", collapsed, "flows in",
+		DataSetName(doc)+"; skipped", skipped, "flows")
+	return true
+}
+
+// regionalized returns true if the group contains a factor with a location.
+func (group *factorGroup) regionalized() bool {
+	for _, f := range group.factors {
+		if f.Location != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// regionalFactors returns the factors of the group that have a location.
+func (group *factorGroup) regionalFactors() []*FlowAmount {
+	var factors []*FlowAmount
+	for _, f := range group.factors {
+		if f.Location != "" {
+			factors = append(factors, f)
+		}
+	}
+	return factors
+}
+
+// value calculates the factor of the given group. It returns false if this
+// is not possible with the strategy. The mean and weighted strategies only
+// use the factors with a location; an unregionalized factor is not an
+// additional region.
+func (d *deregionalizer) value(group *factorGroup) (float64, bool) {
+	switch d.strategy {
+	case strategyMean:
+		factors := group.regionalFactors()
+		if len(factors) == 0 {
+			return 0, false
+		}
+		return meanFactor(factors), true
+	case strategyWeighted:
+		sum, total := 0.0, 0.0
+		for _, f := range group.regionalFactors() {
+			if w, ok := d.weights[NormKey(f.Location)]; ok {
+				sum += w * f.Amount
+				total += w
+			}
+		}
+		if total == 0 {
+			return 0, false
+		}
+		return sum / total, true
+	default:
+		var glo *FlowAmount
+		for _, f := range group.factors {
+			switch NormKey(f.Location) {
+			case "":
+				return f.Amount, true
+			case "glo":
+				glo = f
+			}
+		}
+		if glo == nil {
+			return 0, false
+		}
+		return glo.Amount, true
+	}
+}
+
+func meanFactor(factors []*FlowAmount) float64 {
+	if len(factors) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, f := range factors {
+		sum += f.Amount
+	}
+	return sum / float64(len(factors))
+}
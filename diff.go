@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// DiffReport contains the differences between two ILCD packages. Data sets
+// that differ only in their bytes but not in their XML content (e.g. in
+// whitespace, namespace prefixes, or the order of attributes) are listed as
+// reformatted and not as changed.
+type DiffReport struct {
+	Old         string       `json:"old"`
+	New         string       `json:"new"`
+	Added       []*DiffEntry `json:"added"`
+	Removed     []*DiffEntry `json:"removed"`
+	Changed     []*DiffEntry `json:"changed"`
+	Reformatted []*DiffEntry `json:"reformatted"`
+}
+
+// DiffEntry describes a data set or external document that was added,
+// removed, or changed. For external documents, the UUID is the name of the
+// document.
+type DiffEntry struct {
+	Type       string          `json:"type"`
+	UUID       string          `json:"uuid"`
+	Name       string          `json:"name,omitempty"`
+	Version    string          `json:"version,omitempty"`
+	OldVersion string          `json:"oldVersion,omitempty"`
+	Amounts    []*AmountChange `json:"amounts,omitempty"`
+}
+
+// AmountChange describes an exchange of a process or a characterization
+// factor of an LCIA method that was added, removed, or changed.
+type AmountChange struct {
+	Change    string   `json:"change"`
+	FlowID    string   `json:"flow"`
+	FlowName  string   `json:"flowName,omitempty"`
+	Direction string   `json:"direction,omitempty"`
+	Location  string   `json:"location,omitempty"`
+	OldAmount *float64 `json:"oldAmount,omitempty"`
+	NewAmount *float64 `json:"newAmount,omitempty"`
+}
+
+// diffItem is an indexed entry of a package.
+type diffItem struct {
+	t       ilcd.DataSetType
+	uuid    string
+	name    string
+	version string
+	hash    [sha1.Size]byte
+
+	// the hash of the normalized XML content of a data set; see `contentHash`
+	content [sha1.Size]byte
+
+	// the summed exchanges of a process or the summed factors of an LCIA
+	// method; see `sumAmounts`
+	amounts map[string]*FlowAmount
+}
+
+func (item *diffItem) entry() *DiffEntry {
+	return &DiffEntry{
+		Type:    item.t.Folder(),
+		UUID:    item.uuid,
+		Name:    item.name,
+		Version: item.version}
+}
+
+func diffCommand(args *Args) {
+	if len(args.Files) != 2 {
+		log.Fatalln("ERROR: two packages are required (Usage: peflocus diff",
+			"<old package> <new package>)")
+	}
+	oldPath := resolvePath(args.WorkDir, args.Files[0])
+	newPath := resolvePath(args.WorkDir, args.Files[1])
+	oldItems := indexPackage(oldPath)
+	newItems := indexPackage(newPath)
+	report := diffPackages(oldItems, newItems)
+	report.Old = oldPath
+	report.New = newPath
+
+	if strings.ToLower(args.Format) == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalln("ERROR: Failed to write diff report", err)
+		}
+		return
+	}
+	printDiff(report)
+}
+
+// resolvePath returns the given path if it exists, otherwise the path
+// relative to the working directory.
+func resolvePath(workdir, path string) string {
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return filepath.Join(workdir, path)
+}
+
+func indexPackage(path string) map[string]*diffItem {
+	reader, err := OpenPackage(path)
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read package", path, ":", err)
+	}
+	defer reader.Close()
+	log.Println("INFO: index package", path)
+	items := make(map[string]*diffItem)
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		t := zipFile.Type()
+		if t < 0 || t == ilcd.Asset {
+			return true
+		}
+		data, err := zipFile.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read entry", zipFile.Path(), err)
+			return true
+		}
+		item := &diffItem{t: t, hash: sha1.Sum(data)}
+		if t == ilcd.ExternalDoc {
+			item.uuid = ExternalDocName(zipFile.Path())
+			if item.uuid == "" {
+				return true
+			}
+		} else {
+			if !strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+				return true
+			}
+			doc := etree.NewDocument()
+			if err := doc.ReadFromBytes(data); err != nil {
+				log.Println("ERROR: Failed to parse data set", zipFile.Path(), err)
+				return true
+			}
+			if item.content, err = contentHash(data); err != nil {
+				log.Println("ERROR: Failed to parse data set", zipFile.Path(), err)
+				return true
+			}
+			item.uuid = NormKey(DataSetUUID(doc))
+			item.name = DataSetName(doc)
+			item.version = DataSetVersion(doc)
+			if t == ilcd.ProcessDataSet || t == ilcd.MethodDataSet {
+				item.amounts = sumAmounts(t, doc)
+			}
+		}
+		items[t.Folder()+"/"+item.uuid] = item
+		return true
+	})
+	log.Println(" ... indexed", len(items), "entries")
+	return items
+}
+
+func diffPackages(oldItems, newItems map[string]*diffItem) *DiffReport {
+	report := &DiffReport{
+		Added:       []*DiffEntry{},
+		Removed:     []*DiffEntry{},
+		Changed:     []*DiffEntry{},
+		Reformatted: []*DiffEntry{}}
+	for _, key := range sortedKeys(oldItems) {
+		oldItem := oldItems[key]
+		newItem := newItems[key]
+		if newItem == nil {
+			report.Removed = append(report.Removed, oldItem.entry())
+			continue
+		}
+		if oldItem.hash == newItem.hash {
+			continue
+		}
+		entry := newItem.entry()
+		if oldItem.t != ilcd.ExternalDoc && oldItem.content == newItem.content {
+			report.Reformatted = append(report.Reformatted, entry)
+			continue
+		}
+		if oldItem.version != newItem.version {
+			entry.OldVersion = oldItem.version
+		}
+		if oldItem.amounts != nil && newItem.amounts != nil {
+			entry.Amounts = diffAmounts(oldItem, newItem)
+		}
+		report.Changed = append(report.Changed, entry)
+	}
+	for _, key := range sortedKeys(newItems) {
+		if oldItems[key] == nil {
+			report.Added = append(report.Added, newItems[key].entry())
+		}
+	}
+	return report
+}
+
+// contentHash calculates a hash of the XML content of the given data set that
+// is independent of its formatting: the XML declaration, comments, namespace
+// prefixes, the order of attributes, and whitespace around the text of the
+// elements are ignored.
+func contentHash(data []byte) ([sha1.Size]byte, error) {
+	var buf bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return [sha1.Size]byte{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			var attrs []string
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				attrs = append(attrs, attr.Name.Space+" "+attr.Name.Local+"="+
+					attr.Value)
+			}
+			sort.Strings(attrs)
+			fmt.Fprintf(&buf, "<%s %s %q>", t.Name.Space, t.Name.Local, attrs)
+		case xml.EndElement:
+			buf.WriteString("</>")
+		case xml.CharData:
+			if text := strings.TrimSpace(string(t)); text != "" {
+				fmt.Fprintf(&buf, "%q", text)
+			}
+		}
+	}
+	return sha1.Sum(buf.Bytes()), nil
+}
+
+func sortedKeys(items map[string]*diffItem) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffAmounts compares the exchanges of two process versions or the
+// characterization factors of two LCIA method versions. Amounts of the same
+// flow, direction, and location are summed up.
+func diffAmounts(oldItem, newItem *diffItem) []*AmountChange {
+	oldAmounts := oldItem.amounts
+	newAmounts := newItem.amounts
+	var keys []string
+	for key := range oldAmounts {
+		keys = append(keys, key)
+	}
+	for key := range newAmounts {
+		if oldAmounts[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []*AmountChange
+	for _, key := range keys {
+		oldAmount := oldAmounts[key]
+		newAmount := newAmounts[key]
+		var change *AmountChange
+		switch {
+		case oldAmount == nil:
+			change = amountChange("added", newAmount)
+			change.NewAmount = &newAmount.Amount
+		case newAmount == nil:
+			change = amountChange("removed", oldAmount)
+			change.OldAmount = &oldAmount.Amount
+		case oldAmount.Amount != newAmount.Amount:
+			change = amountChange("changed", newAmount)
+			change.OldAmount = &oldAmount.Amount
+			change.NewAmount = &newAmount.Amount
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// sumAmounts reads the exchanges of a process or the characterization factors
+// of an LCIA method from the given data set and sums up the amounts of the
+// same flow, direction, and location.
+func sumAmounts(t ilcd.DataSetType, doc *etree.Document) map[string]*FlowAmount {
+	var amounts []*FlowAmount
+	if t == ilcd.MethodDataSet {
+		amounts = ReadFactors(doc)
+	} else {
+		amounts = ReadExchanges(doc)
+	}
+	sums := make(map[string]*FlowAmount)
+	for _, a := range amounts {
+		key := a.Key()
+		if sum := sums[key]; sum != nil {
+			sum.Amount += a.Amount
+			continue
+		}
+		sums[key] = a
+	}
+	return sums
+}
+
+func amountChange(change string, a *FlowAmount) *AmountChange {
+	return &AmountChange{
+		Change:    change,
+		FlowID:    a.FlowID,
+		FlowName:  a.FlowName,
+		Direction: a.Direction,
+		Location:  a.Location}
+}
+
+func printDiff(report *DiffReport) {
+	fmt.Println("Diff", report.Old, "->", report.New)
+	fmt.Println("\nAdded:", len(report.Added))
+	for _, entry := range report.Added {
+		fmt.Println("  +", entry.Type, entry.UUID, entry.Name, entry.Version)
+	}
+	fmt.Println("\nRemoved:", len(report.Removed))
+	for _, entry := range report.Removed {
+		fmt.Println("  -", entry.Type, entry.UUID, entry.Name, entry.Version)
+	}
+	fmt.Println("\nChanged:", len(report.Changed))
+	for _, entry := range report.Changed {
+		version := entry.Version
+		if entry.OldVersion != "" {
+			version = entry.OldVersion + " -> " + entry.Version
+		}
+		fmt.Println("  ~", entry.Type, entry.UUID, entry.Name, version)
+		for _, c := range entry.Amounts {
+			flow := c.FlowID
+			if c.FlowName != "" {
+				flow += " (" + c.FlowName + ")"
+			}
+			switch c.Change {
+			case "added":
+				fmt.Println("    +", flow, c.Direction, c.Location, *c.NewAmount)
+			case "removed":
+				fmt.Println("    -", flow, c.Direction, c.Location, *c.OldAmount)
+			default:
+				fmt.Println("    ~", flow, c.Direction, c.Location,
+					*c.OldAmount, "->", *c.NewAmount)
+			}
+		}
+	}
+	fmt.Println("\nReformatted (byte-only changes):", len(report.Reformatted))
+	for _, entry := range report.Reformatted {
+		fmt.Println("  ~", entry.Type, entry.UUID, entry.Name, entry.Version)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/msrocka/ilcd"
+)
+
+// EntryStats counts the entries of a package per entry type that were copied,
+// transformed, skipped, or dropped when writing an output package.
+type EntryStats struct {
+	// entry type -> copied, transformed, skipped, dropped
+	counts map[string]*[4]int
+}
+
+// NewEntryStats creates a new and empty entry statistics.
+func NewEntryStats() *EntryStats {
+	return &EntryStats{counts: make(map[string]*[4]int)}
+}
+
+// EntryType returns the type of the package entry with the given path that is
+// used in the entry statistics: the folder name of the data set type for data
+// sets and external documents, `META-INF` for meta data files, `folders` for
+// folder entries, and `other files` for everything else, like images or other
+// assets.
+func EntryType(path string) string {
+	if strings.HasSuffix(path, "/") {
+		return "folders"
+	}
+	if t := GetPathType(path); t >= 0 && t != ilcd.Asset {
+		return t.Folder()
+	}
+	if strings.Contains(strings.ToLower(path), "meta-inf/") {
+		return "META-INF"
+	}
+	return "other files"
+}
+
+// IsDataSetEntry returns true if the entry with the given path is an ILCD
+// data set; these are the entries that are transformed by the flow mappings.
+func IsDataSetEntry(path string) bool {
+	if strings.HasSuffix(path, "/") {
+		return false
+	}
+	t := GetPathType(path)
+	return t >= 0 && t != ilcd.ExternalDoc && t != ilcd.Asset
+}
+
+// Copied records that the entry with the given path was copied unchanged.
+func (s *EntryStats) Copied(path string) {
+	s.count(path, 0)
+}
+
+// Transformed records that the entry with the given path was changed or that
+// a new entry was created from it.
+func (s *EntryStats) Transformed(path string) {
+	s.count(path, 1)
+}
+
+// Skipped records that the entry with the given path was not written to the
+// output package.
+func (s *EntryStats) Skipped(path string) {
+	s.count(path, 2)
+}
+
+// Dropped records that the entry with the given path was not written to the
+// output package because it is not used, e.g. a flow that is not referenced
+// by any data set of the package.
+func (s *EntryStats) Dropped(path string) {
+	s.count(path, 3)
+}
+
+func (s *EntryStats) count(path string, i int) {
+	t := EntryType(path)
+	c := s.counts[t]
+	if c == nil {
+		c = &[4]int{}
+		s.counts[t] = c
+	}
+	c[i]++
+}
+
+// Log writes the summary of the statistics to the log.
+func (s *EntryStats) Log() {
+	types := make([]string, 0, len(s.counts))
+	for t := range s.counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	log.Println("INFO: Package entries")
+	for _, t := range types {
+		c := s.counts[t]
+		log.Println(" ...", t+":", c[0], "copied,", c[1], "transformed,", c[2],
+			"skipped,", c[3], "dropped (unused)")
+	}
+}
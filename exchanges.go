@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// FlowAmount is an exchange of a process or a characterization factor of an
+// LCIA method: an amount of a flow in a direction with an optional location.
+type FlowAmount struct {
+	// the data set internal ID of an exchange; empty for factors
+	InternalID string
+	FlowID     string
+	FlowName   string
+	Direction  string
+	Location   string
+	Amount     float64
+}
+
+// Key returns the key of the flow amount: <location>/<flow uuid>/<direction>.
+func (a *FlowAmount) Key() string {
+	return MapKey(a.Location, a.FlowID) + "/" + NormKey(a.Direction)
+}
+
+// ReadExchanges reads the exchanges of the given process data set. The
+// resulting amount of an exchange is used if present, otherwise the mean
+// amount.
+func ReadExchanges(doc *etree.Document) []*FlowAmount {
+	var amounts []*FlowAmount
+	for _, e := range doc.FindElements("./processDataSet/exchanges/exchange") {
+		a := readFlowAmount(e)
+		if a == nil {
+			continue
+		}
+		a.InternalID = e.SelectAttrValue("dataSetInternalID", "")
+		if !readNumber(e, "./resultingAmount", &a.Amount) {
+			readNumber(e, "./meanAmount", &a.Amount)
+		}
+		amounts = append(amounts, a)
+	}
+	return amounts
+}
+
+// ReadFactors reads the characterization factors of the given LCIA method
+// data set.
+func ReadFactors(doc *etree.Document) []*FlowAmount {
+	var amounts []*FlowAmount
+	for _, e := range doc.FindElements("./LCIAMethodDataSet/characterisationFactors/factor") {
+		a := readFlowAmount(e)
+		if a == nil {
+			continue
+		}
+		readNumber(e, "./meanValue", &a.Amount)
+		amounts = append(amounts, a)
+	}
+	return amounts
+}
+
+func readFlowAmount(e *etree.Element) *FlowAmount {
+	flowRef := e.FindElement("./referenceToFlowDataSet")
+	if flowRef == nil {
+		return nil
+	}
+	a := &FlowAmount{
+		FlowID:    strings.TrimSpace(flowRef.SelectAttrValue("refObjectId", "")),
+		Direction: childText(e, "./exchangeDirection"),
+		Location:  childText(e, "./location")}
+	if a.FlowID == "" {
+		return nil
+	}
+	a.FlowName = childText(flowRef, "./shortDescription")
+	return a
+}
+
+// childText returns the trimmed text of the element with the given path
+// relative to the given element or an empty string if it does not exist.
+func childText(e *etree.Element, path string) string {
+	child := e.FindElement(path)
+	if child == nil {
+		return ""
+	}
+	return strings.TrimSpace(child.Text())
+}
+
+// readNumber reads the number of the element with the given path into the
+// given value. It returns false if there is no such element or if it does
+// not contain a valid number.
+func readNumber(e *etree.Element, path string, val *float64) bool {
+	text := childText(e, path)
+	if text == "" {
+		return false
+	}
+	num, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return false
+	}
+	*val = num
+	return true
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// Extractor extracts a subset of the data sets of the ILCD packages in a
+// working directory into a single package.
+type Extractor struct {
+	workdir   string
+	recursive bool
+	withDeps  bool
+
+	// the filters; nil or empty if not set
+	types   map[ilcd.DataSetType]bool
+	uuids   map[string]bool
+	class   []string
+	pattern *regexp.Regexp
+
+	// the merger is used to write the selected data sets
+	merger *Merger
+}
+
+// NewExtractor initializes a new extractor from the given arguments.
+func NewExtractor(args *Args) *Extractor {
+	e := &Extractor{
+		workdir:   args.WorkDir,
+		recursive: args.IsRecursive(),
+		withDeps:  isTrue(args.Deps),
+		merger:    NewMerger(args)}
+
+	if args.Type != "" {
+		e.types = make(map[ilcd.DataSetType]bool)
+		for _, name := range strings.Split(args.Type, ",") {
+			t := ParseDataSetType(name)
+			if t < 0 {
+				log.Fatalln("ERROR: unknown data set type", name)
+			}
+			e.types[t] = true
+		}
+	}
+	if args.UUIDs != "" {
+		e.uuids = readUUIDs(args.UUIDs)
+	}
+	if args.Class != "" {
+		for _, class := range strings.Split(args.Class, "/") {
+			e.class = append(e.class, NormKey(class))
+		}
+	}
+	if args.Name != "" {
+		pattern, err := regexp.Compile(args.Name)
+		if err != nil {
+			log.Fatalln("ERROR: invalid name pattern", args.Name, err)
+		}
+		e.pattern = pattern
+	}
+	if e.types == nil && e.uuids == nil && e.class == nil && e.pattern == nil {
+		log.Fatalln("ERROR: no filter given; use the -type, -uuids, -class,",
+			"or -name options")
+	}
+	return e
+}
+
+// readUUIDs reads the UUIDs from the given value. This can be the path to a
+// file with a UUID in the first column of each line or a comma separated
+// list of UUIDs.
+func readUUIDs(val string) map[string]bool {
+	uuids := make(map[string]bool)
+	if _, err := os.Stat(val); err != nil {
+		for _, id := range strings.Split(val, ",") {
+			if id = NormKey(id); id != "" {
+				uuids[id] = true
+			}
+		}
+		return uuids
+	}
+
+	f, err := os.Open(val)
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read UUID file", val, err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(bufio.NewReader(f))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read UUID file", val, err)
+	}
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		if id := NormKey(row[0]); id != "" {
+			uuids[id] = true
+		}
+	}
+	log.Println("INFO: read", len(uuids), "UUIDs from", val)
+	return uuids
+}
+
+// Run executes the extraction.
+func (e *Extractor) Run() {
+	destPath := filepath.Join(e.workdir, "peflocus_extracted.zip")
+	writer, closeFn := createZip(destPath)
+	defer closeFn()
+	for _, name := range GetPackageNames(e.workdir, e.recursive) {
+		reader, err := OpenPackage(filepath.Join(e.workdir, name))
+		if err != nil {
+			log.Println("ERROR: failed to read package", name, ": ", err)
+			continue
+		}
+		log.Println("INFO: extract data sets from", name)
+		e.doIt(reader, writer)
+		if err = reader.Close(); err != nil {
+			log.Println("ERROR: failed to close package", name, ": ", err)
+		}
+	}
+	log.Println("INFO: extracted", len(e.merger.content), "entries into", destPath)
+}
+
+func (e *Extractor) doIt(reader *PackageReader, writer *zip.Writer) {
+	var selected []*ilcd.ZipFile
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		if e.matches(zipFile) {
+			selected = append(selected, zipFile)
+		}
+		return true
+	})
+	log.Println(" ... selected", len(selected), "entries")
+	if e.withDeps {
+		selected = NewDependencies(reader.ZipReader).Closure(selected...)
+		log.Println(" ... with dependencies", len(selected), "entries")
+	}
+
+	// the selected entries are copied as streams from the zip file
+	source, err := zip.OpenReader(reader.ZipPath())
+	if err != nil {
+		log.Println("ERROR: failed to open zip file", reader.ZipPath(), err)
+		return
+	}
+	defer source.Close()
+	files := make(map[string]*zip.File)
+	for _, f := range source.File {
+		files[f.Name] = f
+	}
+	for _, zipFile := range selected {
+		if f := files[zipFile.Path()]; f != nil {
+			e.merger.add(writer, f)
+		}
+	}
+}
+
+// matches returns true if the given zip entry matches all filters.
+func (e *Extractor) matches(zipFile *ilcd.ZipFile) bool {
+	t := zipFile.Type()
+	if t < 0 || t == ilcd.Asset {
+		return false
+	}
+	if e.types != nil && !e.types[t] {
+		return false
+	}
+	if t == ilcd.ExternalDoc {
+		// external documents can be only selected by their type
+		return e.uuids == nil && e.class == nil && e.pattern == nil
+	}
+	if !strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+		return false
+	}
+	if e.uuids == nil && e.class == nil && e.pattern == nil {
+		return true
+	}
+
+	data, err := zipFile.Read()
+	if err != nil {
+		log.Println("ERROR: could not read zip entry", zipFile.Path(), err)
+		return false
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		log.Println("ERROR: could not parse data set", zipFile.Path(), err)
+		return false
+	}
+	if e.uuids != nil && !e.uuids[NormKey(DataSetUUID(doc))] {
+		return false
+	}
+	if e.class != nil && !e.matchesClass(ClassificationOf(doc)) {
+		return false
+	}
+	if e.pattern != nil && !e.pattern.MatchString(DataSetName(doc)) {
+		return false
+	}
+	return true
+}
+
+// matchesClass returns true if the given class path starts with the class
+// path of the filter.
+func (e *Extractor) matchesClass(path []string) bool {
+	if len(path) < len(e.class) {
+		return false
+	}
+	for i, class := range e.class {
+		if NormKey(path[i]) != class {
+			return false
+		}
+	}
+	return true
+}
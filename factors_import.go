@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// FactorRow is a characterization factor of the CSV file of the
+// `import-factors` command.
+type FactorRow struct {
+	Row       int
+	FlowID    string
+	Location  string
+	Direction string
+	Value     float64
+}
+
+// importFactors writes the characterization factors of a CSV file into the
+// LCIA method with the UUID given in the `-methods` option. Factors with the
+// same flow, location, and direction are replaced, other factors are
+// appended. For each package that contains the method, a copy
+// `peflocus_factors_<package>.zip` with the updated method is created.
+func importFactors(args *Args) {
+	if args.Factors == "" {
+		log.Fatalln("ERROR: No factor file given (-factors)")
+	}
+	methodIDs := readUUIDs(args.Methods)
+	if len(methodIDs) != 1 {
+		log.Fatalln("ERROR: The UUID of exactly one LCIA method must be given",
+			"(-methods)")
+	}
+	var methodID string
+	for id := range methodIDs {
+		methodID = id
+	}
+	rows := readFactorRows(args.Factors)
+
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		zipFile := reader.FindDataSet(ilcd.MethodDataSet, methodID)
+		if zipFile == nil {
+			log.Println("INFO: LCIA method", methodID, "not found in", path)
+			reader.Close()
+			continue
+		}
+		log.Println("INFO: Import factors into LCIA method", methodID, "of", path)
+		data, err := zipFile.Read()
+		if err == nil {
+			data, err = writeFactors(data, rows, NewFlowIndex(reader.ZipReader))
+		}
+		if err != nil {
+			log.Println("ERROR: Failed to update LCIA method", zipFile.Path(), err)
+			reader.Close()
+			continue
+		}
+		target := OutputPath(args.WorkDir, name, "peflocus_factors_")
+		copyPackageWith(reader.ZipReader, target, map[string][]byte{
+			zipFile.Path(): data})
+		reader.Close()
+	}
+}
+
+// readFactorRows reads the factors from the given CSV file with the columns:
+// flow UUID, location, direction, value. The first row is ignored.
+func readFactorRows(file string) []*FactorRow {
+	log.Println("INFO: Read characterization factors from", file)
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read factor file", file, err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read factor file", file, err)
+	}
+	var rows []*FactorRow
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) < 4 {
+			log.Println("WARNING: invalid factor in row", i)
+			continue
+		}
+		row := &FactorRow{
+			Row:       i,
+			FlowID:    strings.TrimSpace(record[0]),
+			Location:  strings.TrimSpace(record[1]),
+			Direction: strings.TrimSpace(record[2])}
+		switch strings.ToLower(row.Direction) {
+		case "input":
+			row.Direction = "Input"
+		case "output":
+			row.Direction = "Output"
+		default:
+			log.Println("WARNING: invalid direction", row.Direction, "in row", i)
+			continue
+		}
+		row.Value, err = strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+		if err != nil {
+			log.Println("WARNING: invalid value", record[3], "in row", i)
+			continue
+		}
+		if row.Location != "" && !Locations().Contains(row.Location) {
+			log.Println("WARNING: unknown location code", row.Location, "in row", i)
+		}
+		rows = append(rows, row)
+	}
+	log.Println(" ... read", len(rows), "factors")
+	return rows
+}
+
+// writeFactors replaces or appends the given factors in the given LCIA method
+// data set. Factors of flows that are not contained in the package are
+// skipped.
+func writeFactors(data []byte, rows []*FactorRow, flows *FlowIndex) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+	root := doc.Root()
+	factors := root.FindElement("./characterisationFactors")
+	if factors == nil {
+		factors = etree.NewElement("characterisationFactors")
+		appendIndented(root, factors)
+	}
+
+	// <location>/<flow uuid>/<direction> -> factor element
+	index := make(map[string]*etree.Element)
+	for _, e := range factors.SelectElements("factor") {
+		if a := readFlowAmount(e); a != nil {
+			index[a.Key()] = e
+		}
+	}
+
+	replaced, added, skipped := 0, 0, 0
+	for _, row := range rows {
+		flow := flows.Get(row.FlowID)
+		if flow == nil {
+			log.Println(" ... ERROR: the flow", row.FlowID, "in row", row.Row,
+				"is not contained in the package")
+			skipped++
+			continue
+		}
+		if !flow.IsElementary() {
+			log.Println(" ... WARNING: the flow", row.FlowID, "in row", row.Row,
+				"is not an elementary flow")
+		}
+		a := &FlowAmount{
+			FlowID:    row.FlowID,
+			Location:  row.Location,
+			Direction: row.Direction}
+		value := strconv.FormatFloat(row.Value, 'g', -1, 64)
+		if e := index[a.Key()]; e != nil {
+			meanValue := e.FindElement("./meanValue")
+			if meanValue == nil {
+				meanValue = etree.NewElement("meanValue")
+				appendIndented(e, meanValue)
+			}
+			meanValue.SetText(value)
+			replaced++
+			continue
+		}
+
+		e := etree.NewElement("factor")
+		ref := e.CreateElement("referenceToFlowDataSet")
+		ref.CreateAttr("type", "flow data set")
+		ref.CreateAttr("refObjectId", flow.UUID)
+		ref.CreateAttr("uri", "../flows/"+flow.UUID+".xml")
+		if flow.Name != "" {
+			desc := ref.CreateElement("common:shortDescription")
+			desc.CreateAttr("xml:lang", "en")
+			desc.SetText(flow.Name)
+		}
+		if row.Location != "" {
+			e.CreateElement("location").SetText(row.Location)
+		}
+		e.CreateElement("exchangeDirection").SetText(row.Direction)
+		e.CreateElement("meanValue").SetText(value)
+		appendIndented(factors, e)
+		index[a.Key()] = e
+		added++
+	}
+	log.Println(" ... replaced", replaced, "factors, added", added,
+		"factors, skipped", skipped, "factors")
+	return doc.WriteToBytes()
+}
+
+// copyPackageWith copies the entries of the given package into a new package
+// where the entries with the given paths are replaced by the given data.
+func copyPackageWith(reader *ilcd.ZipReader, target string,
+	replaced map[string][]byte) {
+	DeleteExisting(target)
+	writer, err := ilcd.NewZipWriter(target)
+	if err != nil {
+		log.Println("ERROR: Failed to create zip writer for", target, ":", err)
+		return
+	}
+	defer writer.Close()
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		content, ok := replaced[zipFile.Path()]
+		if !ok {
+			var err error
+			if content, err = zipFile.Read(); err != nil {
+				log.Println("ERROR: Failed to read", zipFile.Path(), err)
+				return true
+			}
+		}
+		if err := writer.Write(zipFile.Path(), content); err != nil {
+			log.Println("ERROR: Failed to write", zipFile.Path(), err)
+		}
+		return true
+	})
+	log.Println(" ... wrote package", target)
+}
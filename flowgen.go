@@ -1,6 +1,7 @@
 package main
 
 import (
+	"archive/zip"
 	"errors"
 	"log"
 	"strings"
@@ -19,13 +20,20 @@ type FlowGenerator struct {
 	reader *ilcd.ZipReader
 
 	// the zip writer where this generator generates the new flows.
-	writer *ilcd.ZipWriter
+	writer *zip.Writer
 
 	// the flow map which the generator uses
 	flowMap *FlowMap
 
 	// indicates whether this generator should generate the mapped or unmapped flows.
 	forMapped bool
+
+	// indicates whether the names of the locations should be added to or
+	// removed from the `mixAndLocationTypes` of the flow names
+	locNames bool
+
+	// optional statistics in which the generated flows are recorded
+	stats *EntryStats
 }
 
 type genFlowInfo struct {
@@ -69,11 +77,14 @@ func (gen *FlowGenerator) Generate() {
 		}
 
 		newEntry := gen.folder + genInfo.targetID + ".xml"
-		if err = gen.writer.Write(newEntry, data); err != nil {
+		if err = writeEntry(gen.writer, newEntry, data); err != nil {
 			log.Println(" ... ERROR: Failed to write new flow", newEntry, err)
 			continue
 		}
 		generated[genInfo.targetID] = true
+		if gen.stats != nil {
+			gen.stats.Transformed(newEntry)
+		}
 
 	}
 	log.Println(" ... generated", len(generated), "new flows")
@@ -122,9 +133,15 @@ func (gen *FlowGenerator) doIt(genInfo *genFlowInfo, data []byte) ([]byte, error
 		name := elem.Text()
 		if gen.forMapped && genInfo.location != "" {
 			elem.SetText(name + " - " + genInfo.location)
+			if locName := Locations().Name(genInfo.location); gen.locNames && locName != "" {
+				addLocationType(elem.Parent(), locName)
+			}
 		} else if !gen.forMapped {
 			locIdx := strings.LastIndex(name, " - ")
 			if locIdx > 0 {
+				if locName := Locations().Name(name[locIdx+3:]); gen.locNames && locName != "" {
+					removeLocationType(elem.Parent(), locName)
+				}
 				name = name[:locIdx]
 				elem.SetText(name)
 			}
@@ -132,3 +149,30 @@ func (gen *FlowGenerator) doIt(genInfo *genFlowInfo, data []byte) ([]byte, error
 	}
 	return doc.WriteToBytes()
 }
+
+// addLocationType adds the name of the location as `mixAndLocationTypes` to
+// the given flow name element if it has no such element yet.
+func addLocationType(nameElem *etree.Element, locName string) {
+	if nameElem.SelectElement("mixAndLocationTypes") != nil {
+		return
+	}
+	mix := etree.NewElement("mixAndLocationTypes")
+	mix.CreateAttr("xml:lang", "en")
+	mix.SetText(locName)
+
+	// the element comes after `baseName` and `treatmentStandardsRoutes`
+	if props := nameElem.SelectElement("flowProperties"); props != nil {
+		nameElem.InsertChild(props, mix)
+	} else {
+		nameElem.AddChild(mix)
+	}
+}
+
+// removeLocationType removes the `mixAndLocationTypes` element from the
+// given flow name element if it contains the given location name.
+func removeLocationType(nameElem *etree.Element, locName string) {
+	mix := nameElem.SelectElement("mixAndLocationTypes")
+	if mix != nil && strings.TrimSpace(mix.Text()) == locName {
+		nameElem.RemoveChild(mix)
+	}
+}
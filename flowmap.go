@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/beevik/etree"
@@ -40,6 +45,32 @@ type FlowMap struct {
 	// Contains the IDs of the flows that where used but not (un)mapped. These
 	// flows should be copied into the target archive.
 	untouchedUsed map[string]bool
+
+	// (process UUID/flow UUID/direction) -> location of the exchanges in the
+	// processes of the current package; see `IndexLocations`
+	locations map[string]string
+
+	// the optional location hierarchy that is used when there is no mapping
+	// for a flow in a location
+	hierarchy *LocationHierarchy
+
+	// (location/OldID) -> the fallback that was used for flows in locations
+	// without a direct mapping
+	fallbacks map[string]*MapFallback
+}
+
+// MapFallback describes a mapping of a flow in a location that was found via
+// the location hierarchy.
+type MapFallback struct {
+	FlowID   string
+	Location string
+
+	// the location of the mapping that was used
+	Fallback string
+	NewID    string
+
+	// the number of mapped exchanges, factors, and model links
+	Count int
 }
 
 // ReadFlowMap reads the flow mappings from the given file.
@@ -58,7 +89,8 @@ func ReadFlowMap(file string) *FlowMap {
 		mappings:      make(map[string]*FlowMapEntry),
 		unmappings:    make(map[string]*FlowMapEntry),
 		used:          make(map[string]bool),
-		untouchedUsed: make(map[string]bool)}
+		untouchedUsed: make(map[string]bool),
+		fallbacks:     make(map[string]*MapFallback)}
 	for i, row := range rows {
 		if i == 0 {
 			continue
@@ -71,6 +103,9 @@ func ReadFlowMap(file string) *FlowMap {
 			OldID:    strings.TrimSpace(row[0]),
 			Location: strings.TrimSpace(row[1]),
 			NewID:    strings.TrimSpace(row[2])}
+		if e.Location != "" && !Locations().Contains(e.Location) {
+			log.Println("WARNING: unknown location code", e.Location, "in row", i)
+		}
 		key := MapKey(e.Location, e.OldID)
 		fm.mappings[key] = &e
 		fm.unmappings[e.NewID] = &e
@@ -83,132 +118,345 @@ func ReadFlowMap(file string) *FlowMap {
 func (m *FlowMap) ResetStats() {
 	m.used = make(map[string]bool)
 	m.untouchedUsed = make(map[string]bool)
+	m.fallbacks = make(map[string]*MapFallback)
+}
+
+// replacedFlows returns the normalized UUIDs of the flows that were replaced
+// by the (un)mapping of the current package.
+func (m *FlowMap) replacedFlows() map[string]bool {
+	ids := make(map[string]bool)
+	for key := range m.used {
+		// location/OldID in map-mode, NewID in unmap-mode
+		ids[NormKey(key[strings.LastIndex(key, "/")+1:])] = true
+	}
+	return ids
+}
+
+// SetHierarchy sets the location hierarchy that is used to find a mapping
+// for a flow in a location without a direct mapping.
+func (m *FlowMap) SetHierarchy(h *LocationHierarchy) {
+	m.hierarchy = h
+}
+
+// isFallback returns true if the given mapping location is a fallback
+// location of the given exchange location in the location hierarchy.
+func (m *FlowMap) isFallback(location, mappingLocation string) bool {
+	if mappingLocation == "" || NormKey(location) == NormKey(mappingLocation) {
+		return false
+	}
+	for _, fallback := range m.hierarchy.Fallbacks(location) {
+		if NormKey(fallback) == NormKey(mappingLocation) {
+			return true
+		}
+	}
+	return false
 }
 
-// MapFlows maps the flows in the given data set if it is an LCIA method or
-// process
-func (m *FlowMap) MapFlows(zipEntry string, data []byte) ([]byte, error) {
+// find returns the key and mapping for the given flow and location. If there
+// is no direct mapping and `withFallback` is true, the fallback locations of
+// the hierarchy are tried; the location of the used mapping is returned as
+// third value then.
+func (m *FlowMap) find(location, flowID string,
+	withFallback bool) (string, *FlowMapEntry, string) {
+	key := MapKey(location, flowID)
+	if mapping := m.mappings[key]; mapping != nil {
+		return key, mapping, ""
+	}
+	if !withFallback {
+		return key, nil, ""
+	}
+	for _, fallback := range m.hierarchy.Fallbacks(location) {
+		fkey := MapKey(fallback, flowID)
+		if mapping := m.mappings[fkey]; mapping != nil {
+			return fkey, mapping, mapping.Location
+		}
+	}
+	return key, nil, ""
+}
+
+// recordFallback records that the given mapping was used as fallback for the
+// flow in the given location.
+func (m *FlowMap) recordFallback(location, flowID string, mapping *FlowMapEntry) {
+	key := MapKey(location, flowID)
+	f := m.fallbacks[key]
+	if f == nil {
+		f = &MapFallback{
+			FlowID:   flowID,
+			Location: location,
+			Fallback: mapping.Location,
+			NewID:    mapping.NewID}
+		m.fallbacks[key] = f
+	}
+	f.Count++
+}
+
+// Fallbacks returns the fallbacks of the location hierarchy that were used
+// in the mapping of the current package, sorted by flow and location.
+func (m *FlowMap) Fallbacks() []*MapFallback {
+	fallbacks := make([]*MapFallback, 0, len(m.fallbacks))
+	for _, f := range m.fallbacks {
+		fallbacks = append(fallbacks, f)
+	}
+	sort.Slice(fallbacks, func(i, j int) bool {
+		fi, fj := fallbacks[i], fallbacks[j]
+		if fi.FlowID != fj.FlowID {
+			return fi.FlowID < fj.FlowID
+		}
+		return fi.Location < fj.Location
+	})
+	return fallbacks
+}
+
+// IndexLocations indexes the locations of the exchanges of the processes in
+// the given package. The flow references in the connections of life cycle
+// models are mapped based on the locations of the linked exchanges.
+func (m *FlowMap) IndexLocations(reader *ilcd.ZipReader) {
+	m.locations = make(map[string]string)
+	eachProcessDoc(reader, func(doc *etree.Document) {
+		process := NormKey(DataSetUUID(doc))
+		for _, e := range ReadExchanges(doc) {
+			key := process + "/" + NormKey(e.FlowID) + "/" + NormKey(e.Direction)
+			if _, ok := m.locations[key]; !ok {
+				m.locations[key] = e.Location
+			}
+		}
+	})
+}
+
+// MapFlows maps the flows in the data set with the given zip entry path if it
+// is an LCIA method, process, or life cycle model. The data set is read from
+// the given reader and written into the given writer; other data sets are
+// copied unchanged. It returns true if the data set was changed.
+func (m *FlowMap) MapFlows(zipEntry string, r io.Reader, w io.Writer) (bool, error) {
 	if ilcd.IsMethodPath(zipEntry) {
-		return m.forMethod(data, m.mapFlow)
+		return m.forMethod(r, w, m.mapFactor)
 	}
 	if ilcd.IsProcessPath(zipEntry) {
-		return m.forProcess(data, m.mapFlow)
+		return m.forProcess(r, w, m.mapFlow)
+	}
+	if GetPathType(zipEntry) == ilcd.ModelDataSet {
+		return m.withModel(r, w, m.mapLink)
 	}
-	return data, nil
+	_, err := io.Copy(w, r)
+	return false, err
 }
 
-// UnmapFlows applies a reverse mapping: reasigning the old flow UUIDs.
-func (m *FlowMap) UnmapFlows(zipEntry string, data []byte) ([]byte, error) {
+// UnmapFlows applies a reverse mapping: reasigning the old flow UUIDs. It
+// works like `MapFlows`.
+func (m *FlowMap) UnmapFlows(zipEntry string, r io.Reader, w io.Writer) (bool, error) {
 	if ilcd.IsMethodPath(zipEntry) {
-		return m.forMethod(data, m.unmapFlow)
+		return m.forMethod(r, w, m.unmapFlow)
 	}
 	if ilcd.IsProcessPath(zipEntry) {
-		return m.forProcess(data, m.unmapFlow)
+		return m.forProcess(r, w, m.unmapFlow)
 	}
-	return data, nil
+	if GetPathType(zipEntry) == ilcd.ModelDataSet {
+		return m.withModel(r, w, m.unmapLink)
+	}
+	_, err := io.Copy(w, r)
+	return false, err
 }
 
-func (m *FlowMap) forMethod(data []byte, fn func(e *etree.Element)) ([]byte, error) {
-	doc := etree.NewDocument()
-	if err := doc.ReadFromBytes(data); err != nil {
-		return nil, err
+// IsMappedPair returns true if the given flows are related by the mapping:
+// either the flow with the given location is mapped to the other flow or the
+// flow is a new flow of the mapping and the other flow the original flow.
+func (m *FlowMap) IsMappedPair(flowID, otherID, location string) bool {
+	if _, e, _ := m.find(location, flowID, true); e != nil && e.NewID == otherID {
+		return true
 	}
-	uuidElem := doc.FindElement("./LCIAMethodDataSet/LCIAMethodInformation/dataSetInformation/UUID")
-	if uuidElem != nil {
-		log.Println("Replace flows in LCIA method", uuidElem.Text())
+	if e := m.unmappings[flowID]; e != nil && e.OldID == otherID {
+		return true
 	}
-	factors := doc.FindElements("./LCIAMethodDataSet/characterisationFactors/factor")
-	log.Println(" ... check", len(factors), "factors")
-	for _, factor := range factors {
-		fn(factor)
+	return false
+}
+
+func (m *FlowMap) forMethod(r io.Reader, w io.Writer,
+	fn func(ref *flowRef)) (bool, error) {
+	changed, count, err := rewriteFlowRefs(r, w, factorPath, fn)
+	log.Println(" ... checked", count, "factors")
+	return changed, err
+}
+
+func (m *FlowMap) forProcess(r io.Reader, w io.Writer,
+	fn func(ref *flowRef)) (bool, error) {
+	changed, count, err := rewriteFlowRefs(r, w, exchangePath, fn)
+	log.Println(" ... checked", count, "exchanges")
+	return changed, err
+}
+
+// withModel reads the complete life cycle model from the given reader as the
+// connections can reference process instances that are defined later in the
+// data set, see `forModel`. Models are small compared to LCIA methods or
+// processes with many exchanges.
+func (m *FlowMap) withModel(r io.Reader, w io.Writer,
+	fn func(attr *xml.Attr, process, direction string)) (bool, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	converted, err := m.forModel(data, fn)
+	if err != nil {
+		// write the model unchanged so that the entry is complete
+		w.Write(data)
+		return false, err
+	}
+	if _, err := w.Write(converted); err != nil {
+		return false, err
 	}
-	return doc.WriteToBytes()
+	return !bytes.Equal(converted, data), nil
 }
 
-func (m *FlowMap) forProcess(data []byte, fn func(e *etree.Element)) ([]byte, error) {
-	doc := etree.NewDocument()
-	if err := doc.ReadFromBytes(data); err != nil {
+// forModel applies the given function on the flow references of the
+// connections in the given life cycle model. The function is called with the
+// `flowUUID` attribute, the UUID of the linked process, and the direction of
+// the linked exchange in that process. The process instances and the
+// connections are collected in a single pass over the tokens of the data set;
+// as a connection can reference a process instance that is defined later, the
+// function is called at the end. Changed attributes are patched into the raw
+// XML so that the rest of the data set is kept as it is.
+func (m *FlowMap) forModel(data []byte,
+	fn func(attr *xml.Attr, process, direction string)) ([]byte, error) {
+
+	// internal ID -> process UUID
+	processes := make(map[string]string)
+
+	// the flow references of the connections with the internal IDs of the
+	// linked process instances
+	type link struct {
+		attr      *xml.Attr
+		instance  string
+		direction string
+	}
+	var links []link
+	var tags []*xmlTag
+
+	modelID := ""
+	instance := ""
+	err := scanTags(data, func(path []string, tag *xmlTag) {
+		switch {
+		case pathEquals(path, instancePath):
+			instance = ""
+			if id := findAttr(&tag.StartElement, "dataSetInternalID"); id != nil {
+				instance = strings.TrimSpace(id.Value)
+			}
+		case pathEquals(path, processRefPath):
+			if ref := findAttr(&tag.StartElement, "refObjectId"); ref != nil {
+				processes[instance] = strings.TrimSpace(ref.Value)
+			}
+		case pathEquals(path, outputExchangePath):
+			if attr := findAttr(&tag.StartElement, "flowUUID"); attr != nil {
+				links = append(links, link{attr, instance, "Output"})
+				tags = append(tags, tag)
+			}
+		case pathEquals(path, downstreamProcessPath):
+			if attr := findAttr(&tag.StartElement, "flowUUID"); attr != nil {
+				recipient := ""
+				if id := findAttr(&tag.StartElement, "id"); id != nil {
+					recipient = strings.TrimSpace(id.Value)
+				}
+				links = append(links, link{attr, recipient, "Input"})
+				tags = append(tags, tag)
+			}
+		}
+	}, func(path []string, text string) {
+		if modelID == "" && pathEquals(path, modelUUIDPath) {
+			modelID = strings.TrimSpace(text)
+		}
+	})
+	if err != nil {
 		return nil, err
 	}
-	uuidElem := doc.FindElement("./processDataSet/processInformation/dataSetInformation/UUID")
-	if uuidElem != nil {
-		log.Println("Replace flows in process", uuidElem.Text())
+	if modelID != "" {
+		log.Println("Replace flows in life cycle model", modelID)
 	}
-	exchanges := doc.FindElements("./processDataSet/exchanges/exchange")
-	log.Println(" ... check", len(exchanges), "exchanges")
-	for _, e := range exchanges {
-		fn(e)
+	for _, l := range links {
+		fn(l.attr, processes[l.instance], l.direction)
 	}
-	return doc.WriteToBytes()
+	log.Println(" ... checked", len(links), "flow references in connections")
+	return patchTags(data, tags), nil
 }
 
-// mapFlow assigns the new flow UUIDs from the mapping to exchanges or LCIA
-// factors with a matching pair of old flow UUID and location.
-func (m *FlowMap) mapFlow(e *etree.Element) {
-	flowRef := e.FindElement("./referenceToFlowDataSet")
-	if flowRef == nil {
+// mapLink assigns the new flow UUID to a flow reference of a model connection
+// if there is a mapping for the flow and the location of the linked exchange.
+func (m *FlowMap) mapLink(attr *xml.Attr, process, direction string) {
+	location := m.locations[NormKey(process)+"/"+NormKey(attr.Value)+"/"+
+		NormKey(direction)]
+	key, mapping, fallback := m.find(location, attr.Value, true)
+	if mapping == nil {
+		m.untouchedUsed[attr.Value] = true
 		return
 	}
-	idAttr := flowRef.SelectAttr("refObjectId")
-	if idAttr == nil {
+	if fallback != "" {
+		m.recordFallback(location, attr.Value, mapping)
+	}
+	attr.Value = mapping.NewID
+	m.used[key] = true
+}
+
+// unmapLink assigns back the old flow UUID to a flow reference of a model
+// connection.
+func (m *FlowMap) unmapLink(attr *xml.Attr, process, direction string) {
+	unmapping := m.unmappings[attr.Value]
+	if unmapping == nil {
+		m.untouchedUsed[attr.Value] = true
 		return
 	}
-	location := ""
-	locElem := e.FindElement("./location")
-	if locElem != nil {
-		location = strings.TrimSpace(locElem.Text())
+	attr.Value = unmapping.OldID
+	m.used[unmapping.NewID] = true
+}
+
+// mapFlow assigns the new flow UUIDs from the mapping to exchanges with a
+// matching pair of old flow UUID and location. If there is no such pair, the
+// fallback locations of the location hierarchy are tried.
+func (m *FlowMap) mapFlow(ref *flowRef) {
+	m.mapRef(ref, true)
+}
+
+// mapFactor assigns the new flow UUIDs from the mapping to LCIA factors with
+// a matching pair of old flow UUID and location. The location hierarchy is
+// not used for factors as this would result in multiple factors for the same
+// flow when there are also factors for the fallback locations.
+func (m *FlowMap) mapFactor(ref *flowRef) {
+	m.mapRef(ref, false)
+}
+
+func (m *FlowMap) mapRef(ref *flowRef, withFallback bool) {
+	if ref.FlowID == "" {
+		return
 	}
-	key := MapKey(location, idAttr.Value)
-	mapping := m.mappings[key]
+	key, mapping, fallback := m.find(ref.Location, ref.FlowID, withFallback)
 	if mapping == nil {
-		m.untouchedUsed[idAttr.Value] = true
+		m.untouchedUsed[ref.FlowID] = true
 		return
 	}
-	idAttr.Value = mapping.NewID
-	uriAttr := flowRef.SelectAttr("uri")
-	if uriAttr != nil {
-		uriAttr.Value = "../flows/" + mapping.NewID + ".xml"
+	if fallback != "" {
+		m.recordFallback(ref.Location, ref.FlowID, mapping)
 	}
+	ref.SetFlow(mapping.NewID)
 	m.used[key] = true
 }
 
-// unmapFlow assigns back the old flow UUID to exchanges and LCIA factors
-// that have a new flow UUID.
-func (m *FlowMap) unmapFlow(e *etree.Element) {
-	flowRef := e.FindElement("./referenceToFlowDataSet")
-	if flowRef == nil {
-		log.Println(" ... ERROR: no flow reference found")
-		return
-	}
-	idAttr := flowRef.SelectAttr("refObjectId")
-	if idAttr == nil {
+// unmapFlow assigns back the old flow UUID and the location of the mapping to
+// exchanges and LCIA factors that have a new flow UUID. If a location
+// hierarchy is set and the location of the mapping is a fallback of the
+// location of the exchange (e.g. an exchange in `DE` that was mapped with the
+// mapping for `EU-28`), the location of the exchange is kept.
+func (m *FlowMap) unmapFlow(ref *flowRef) {
+	if ref.FlowID == "" {
 		log.Println(" ... ERROR: no flow reference found")
 		return
 	}
-	unmapping := m.unmappings[idAttr.Value]
+	unmapping := m.unmappings[ref.FlowID]
 	if unmapping == nil {
-		m.untouchedUsed[idAttr.Value] = true
+		m.untouchedUsed[ref.FlowID] = true
 		return
 	}
-	idAttr.Value = unmapping.OldID
-	uriAttr := flowRef.SelectAttr("uri")
-	if uriAttr != nil {
-		uriAttr.Value = "../flows/" + unmapping.OldID + ".xml"
+	ref.SetFlow(unmapping.OldID)
+	if !m.isFallback(ref.Location, unmapping.Location) {
+		ref.SetLocation(unmapping.Location)
 	}
-
-	locElem := e.FindElement("./location")
-	if locElem == nil {
-		locElem = etree.NewElement("location")
-		e.InsertChild(e.FindElement("./exchangeDirection"), locElem)
-	}
-	locElem.SetText(unmapping.Location)
-
-	nameElem := e.FindElement("./referenceToFlowDataSet/shortDescription")
-	if nameElem != nil {
-		name := nameElem.Text()
-		name = strings.TrimSuffix(name, " - "+unmapping.Location)
-		nameElem.SetText(name)
+	if name := strings.TrimSuffix(ref.Name, " - "+unmapping.Location); name != ref.Name {
+		ref.SetName(name)
 	}
-
 	m.used[unmapping.NewID] = true
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+const testModel = `<?xml version='1.0' encoding='UTF-8'?>
+<lifeCycleModelDataSet xmlns="http://eplca.jrc.ec.europa.eu/ILCD/LifeCycleModel/2017" xmlns:common="http://lca.jrc.it/ILCD/Common">
+  <lifeCycleModelInformation>
+    <dataSetInformation><common:UUID>44444444-4444-4444-4444-444444444444</common:UUID></dataSetInformation>
+    <technology>
+      <processes>
+        <processInstance dataSetInternalID="1">
+          <referenceToProcess refObjectId="11111111-1111-1111-1111-111111111111"/>
+          <connections>
+            <outputExchange flowUUID = 'aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa'>
+              <downstreamProcess id="2" flowUUID="aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"/>
+            </outputExchange>
+          </connections>
+        </processInstance>
+        <processInstance dataSetInternalID="2">
+          <referenceToProcess refObjectId="22222222-2222-2222-2222-222222222222"/>
+        </processInstance>
+      </processes>
+    </technology>
+  </lifeCycleModelInformation>
+</lifeCycleModelDataSet>`
+
+func TestForModel(t *testing.T) {
+	data := []byte(testModel)
+	var links []string
+	converted, err := (&FlowMap{}).forModel(data,
+		func(attr *xml.Attr, process, direction string) {
+			links = append(links, process+" "+direction)
+			attr.Value = "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the downstream process is defined after the connection
+	want := []string{
+		"11111111-1111-1111-1111-111111111111 Output",
+		"22222222-2222-2222-2222-222222222222 Input"}
+	if strings.Join(links, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got links %v, want %v", links, want)
+	}
+	expected := strings.Replace(testModel, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+		"bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb", -1)
+	if string(converted) != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", converted, expected)
+	}
+}
+
+func TestUnchangedPassthrough(t *testing.T) {
+	// unchanged data sets must be returned byte by byte as they are, including
+	// the XML declaration, comments, quotes, line breaks, and prefixes
+	process := "<?xml version='1.0'?>\r\n<!-- a comment -->\r\n" +
+		"<p:processDataSet xmlns:p=\"http://lca.jrc.it/ILCD/Process\">\r\n" +
+		"  <p:exchanges><p:exchange  dataSetInternalID = '1' >\r\n" +
+		"    <p:referenceToFlowDataSet refObjectId='aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa'/>" +
+		"<p:location>DE</p:location><p:meanAmount>1e0</p:meanAmount>\r\n" +
+		"  </p:exchange></p:exchanges>\r\n</p:processDataSet>\r\n"
+	var buf bytes.Buffer
+	changed, count, err := rewriteFlowRefs(strings.NewReader(process), &buf,
+		exchangePath, func(ref *flowRef) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || count != 1 {
+		t.Errorf("changed=%v count=%d, want false and 1", changed, count)
+	}
+	if buf.String() != process {
+		t.Errorf("got:\n%q\nwant:\n%q", buf.String(), process)
+	}
+
+	// the same slice is returned for unchanged models
+	data := []byte(testModel)
+	converted, err := (&FlowMap{}).forModel(data,
+		func(attr *xml.Attr, process, direction string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(converted) != len(data) || &converted[0] != &data[0] {
+		t.Error("expected the input data for an unchanged model")
+	}
+}
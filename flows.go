@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// FlowInfo contains the information of a flow data set that are needed in
+// calculations and reports.
+type FlowInfo struct {
+	UUID string
+	Name string
+
+	// the type of the flow data set, e.g. `Elementary flow`
+	Type string
+
+	// the UUID of the reference flow property
+	Property string
+
+	// the name of the reference unit of the reference flow property
+	Unit string
+
+	// the UUID of the unit group of the reference flow property
+	UnitGroup string
+}
+
+// IsElementary returns true if the flow is an elementary flow.
+func (f *FlowInfo) IsElementary() bool {
+	return strings.EqualFold(f.Type, "Elementary flow")
+}
+
+// FlowIndex reads and caches the information of the flows of a package.
+type FlowIndex struct {
+	finder DataSetFinder
+
+	// flow UUID -> flow info; nil if the flow does not exist
+	flows map[string]*FlowInfo
+
+	// flow property UUID -> unit name
+	units map[string]string
+
+	// flow property UUID -> unit group UUID
+	unitGroups map[string]string
+}
+
+// NewFlowIndex creates a new flow index that reads the flows from the given
+// package or finder.
+func NewFlowIndex(finder DataSetFinder) *FlowIndex {
+	return &FlowIndex{
+		finder:     finder,
+		flows:      make(map[string]*FlowInfo),
+		units:      make(map[string]string),
+		unitGroups: make(map[string]string)}
+}
+
+// Get returns the information of the flow with the given UUID. It returns
+// nil if the flow is not contained in the package.
+func (idx *FlowIndex) Get(uuid string) *FlowInfo {
+	if info, ok := idx.flows[uuid]; ok {
+		return info
+	}
+	var info *FlowInfo
+	if doc := idx.read(ilcd.FlowDataSet, uuid); doc != nil {
+		info = &FlowInfo{
+			UUID:     uuid,
+			Name:     DataSetName(doc),
+			Property: ReferenceFlowProperty(doc)}
+		if elem := doc.FindElement(
+			"./flowDataSet/modellingAndValidation/LCIMethod/typeOfDataSet"); elem != nil {
+			info.Type = strings.TrimSpace(elem.Text())
+		}
+		info.Unit = idx.unit(info.Property)
+		info.UnitGroup = idx.unitGroups[info.Property]
+	}
+	idx.flows[uuid] = info
+	return info
+}
+
+// unit returns the name of the reference unit of the given flow property.
+func (idx *FlowIndex) unit(property string) string {
+	if property == "" {
+		return ""
+	}
+	if unit, ok := idx.units[property]; ok {
+		return unit
+	}
+	unit := ""
+	if doc := idx.read(ilcd.FlowPropertyDataSet, property); doc != nil {
+		ref := doc.FindElement(
+			"./flowPropertyDataSet/flowPropertiesInformation/quantitativeReference/referenceToReferenceUnitGroup")
+		if ref != nil {
+			unitGroup := strings.TrimSpace(ref.SelectAttrValue("refObjectId", ""))
+			idx.unitGroups[property] = unitGroup
+			unit = idx.refUnit(unitGroup)
+		}
+	}
+	idx.units[property] = unit
+	return unit
+}
+
+// refUnit returns the name of the reference unit of the given unit group.
+func (idx *FlowIndex) refUnit(unitGroup string) string {
+	doc := idx.read(ilcd.UnitGroupDataSet, strings.TrimSpace(unitGroup))
+	if doc == nil {
+		return ""
+	}
+	return ReferenceUnit(doc)
+}
+
+func (idx *FlowIndex) read(t ilcd.DataSetType, uuid string) *etree.Document {
+	if uuid == "" {
+		return nil
+	}
+	zipFile := idx.finder.FindDataSet(t, uuid)
+	if zipFile == nil {
+		return nil
+	}
+	data, err := zipFile.Read()
+	if err != nil {
+		return nil
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil
+	}
+	return doc
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"os"
+	"strings"
+)
+
+// LocationHierarchy contains the parent locations of locations, e.g. `RER`
+// for `DE`. It is used to find a mapping for a flow in a location for which
+// there is no direct mapping. `GLO` is always the last fallback.
+type LocationHierarchy struct {
+	// normalized code -> parent code
+	parents map[string]string
+}
+
+// ReadLocationHierarchy reads the location hierarchy from the given CSV file
+// with the columns: location code, parent location code. The first row is
+// ignored.
+func ReadLocationHierarchy(file string) *LocationHierarchy {
+	log.Println("INFO: Read location hierarchy from", file)
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read location hierarchy", file, err)
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		log.Fatalln("ERROR: Failed to read location hierarchy", file, err)
+	}
+	h := &LocationHierarchy{parents: make(map[string]string)}
+	for i, row := range rows {
+		if i == 0 {
+			continue
+		}
+		if len(row) < 2 {
+			log.Println("WARNING: invalid location hierarchy entry in row", i)
+			continue
+		}
+		location := Locations().Normalize(row[0])
+		parent := Locations().Normalize(row[1])
+		if location == "" || parent == "" {
+			log.Println("WARNING: invalid location hierarchy entry in row", i)
+			continue
+		}
+		for _, code := range []string{location, parent} {
+			if !Locations().Contains(code) {
+				log.Println("WARNING: unknown location code", code, "in row", i)
+			}
+		}
+		h.parents[NormKey(location)] = parent
+	}
+	log.Println(" ... read", len(h.parents), "parent locations")
+	return h
+}
+
+// Fallbacks returns the locations that should be tried, in this order, if
+// there is no mapping for the given location: the parent locations up to the
+// root of the hierarchy and finally `GLO`.
+func (h *LocationHierarchy) Fallbacks(location string) []string {
+	if h == nil || strings.TrimSpace(location) == "" {
+		return nil
+	}
+	var fallbacks []string
+	visited := map[string]bool{NormKey(location): true}
+	code := NormKey(location)
+	for {
+		parent, ok := h.parents[code]
+		if !ok || visited[NormKey(parent)] {
+			break
+		}
+		fallbacks = append(fallbacks, parent)
+		code = NormKey(parent)
+		visited[code] = true
+	}
+	if !visited["glo"] {
+		fallbacks = append(fallbacks, "GLO")
+	}
+	return fallbacks
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// The types of the openLCA JSON-LD format (olca-schema, version 2) that are
+// written by the `export-jsonld` command. Each entity is stored as
+// `<folder>/<@id>.json` in the zip package.
+
+type olcaRef struct {
+	Type string `json:"@type"`
+	ID   string `json:"@id"`
+	Name string `json:"name,omitempty"`
+}
+
+type olcaEntity struct {
+	Type     string `json:"@type"`
+	ID       string `json:"@id"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+type olcaUnit struct {
+	Type             string  `json:"@type"`
+	ID               string  `json:"@id"`
+	Name             string  `json:"name"`
+	ConversionFactor float64 `json:"conversionFactor"`
+	IsRefUnit        bool    `json:"isRefUnit,omitempty"`
+}
+
+type olcaUnitGroup struct {
+	olcaEntity
+	Units []*olcaUnit `json:"units"`
+}
+
+type olcaFlowProperty struct {
+	olcaEntity
+	FlowPropertyType string   `json:"flowPropertyType"`
+	UnitGroup        *olcaRef `json:"unitGroup,omitempty"`
+}
+
+type olcaFlowPropertyFactor struct {
+	Type              string   `json:"@type"`
+	FlowProperty      *olcaRef `json:"flowProperty"`
+	ConversionFactor  float64  `json:"conversionFactor"`
+	IsRefFlowProperty bool     `json:"isRefFlowProperty,omitempty"`
+}
+
+type olcaFlow struct {
+	olcaEntity
+	FlowType       string                    `json:"flowType"`
+	CAS            string                    `json:"cas,omitempty"`
+	Formula        string                    `json:"formula,omitempty"`
+	FlowProperties []*olcaFlowPropertyFactor `json:"flowProperties"`
+}
+
+type olcaLocation struct {
+	olcaEntity
+	Code string `json:"code"`
+}
+
+type olcaExchange struct {
+	Type                    string   `json:"@type"`
+	InternalID              int      `json:"internalId"`
+	Amount                  float64  `json:"amount"`
+	IsInput                 bool     `json:"isInput"`
+	IsQuantitativeReference bool     `json:"isQuantitativeReference,omitempty"`
+	Flow                    *olcaRef `json:"flow"`
+	FlowProperty            *olcaRef `json:"flowProperty"`
+	Unit                    *olcaRef `json:"unit"`
+	Location                *olcaRef `json:"location,omitempty"`
+}
+
+type olcaProcess struct {
+	olcaEntity
+	ProcessType    string          `json:"processType"`
+	Location       *olcaRef        `json:"location,omitempty"`
+	Exchanges      []*olcaExchange `json:"exchanges"`
+	LastInternalID int             `json:"lastInternalId"`
+}
+
+type olcaImpactFactor struct {
+	Type         string   `json:"@type"`
+	Flow         *olcaRef `json:"flow"`
+	FlowProperty *olcaRef `json:"flowProperty"`
+	Unit         *olcaRef `json:"unit"`
+	Location     *olcaRef `json:"location,omitempty"`
+	Value        float64  `json:"value"`
+}
+
+type olcaImpactCategory struct {
+	olcaEntity
+	RefUnit       string              `json:"refUnit,omitempty"`
+	ImpactFactors []*olcaImpactFactor `json:"impactFactors"`
+}
+
+type olcaImpactMethod struct {
+	olcaEntity
+	ImpactCategories []*olcaRef `json:"impactCategories"`
+}
+
+// exportJSONLD converts the packages in the working directory into openLCA
+// JSON-LD packages `peflocus_jsonld_<package>.zip`.
+func exportJSONLD(args *Args) {
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		target := OutputPath(args.WorkDir, name, "peflocus_jsonld_")
+		log.Println("INFO: Export", path, "to JSON-LD package", target)
+		DeleteExisting(target)
+		writer, err := ilcd.NewZipWriter(target)
+		if err != nil {
+			log.Println("ERROR: Failed to create zip writer for", target, ":", err)
+			reader.Close()
+			continue
+		}
+		e := &jsonldExport{
+			reader:    reader.ZipReader,
+			writer:    writer,
+			flows:     NewFlowIndex(reader.ZipReader),
+			locations: make(map[string]bool),
+			counts:    make(map[string]int)}
+		e.run(strings.TrimSuffix(filepath.Base(name), ".zip"))
+		writer.Close()
+		reader.Close()
+	}
+}
+
+// jsonldExport converts the data sets of a package into JSON-LD.
+type jsonldExport struct {
+	reader *ilcd.ZipReader
+	writer *ilcd.ZipWriter
+	flows  *FlowIndex
+
+	// the IDs of the locations that were already written
+	locations map[string]bool
+
+	// the references of the written impact categories
+	categories []*olcaRef
+
+	// folder -> number of written entities
+	counts map[string]int
+}
+
+func (e *jsonldExport) run(packageName string) {
+	e.writeJSON("olca-schema.json", map[string]int{"version": 2})
+	converters := []struct {
+		t  ilcd.DataSetType
+		fn func(doc *etree.Document)
+	}{
+		{ilcd.UnitGroupDataSet, e.unitGroup},
+		{ilcd.FlowPropertyDataSet, e.flowProperty},
+		{ilcd.FlowDataSet, e.flow},
+		{ilcd.ProcessDataSet, e.process},
+		{ilcd.MethodDataSet, e.impactCategory},
+	}
+	for _, c := range converters {
+		e.reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+			if zipFile.Type() != c.t ||
+				!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+				return true
+			}
+			data, err := zipFile.Read()
+			if err != nil {
+				log.Println("ERROR: Failed to read", zipFile.Path(), err)
+				return true
+			}
+			doc := etree.NewDocument()
+			if err := doc.ReadFromBytes(data); err != nil {
+				log.Println("ERROR: Failed to parse", zipFile.Path(), err)
+				return true
+			}
+			c.fn(doc)
+			return true
+		})
+	}
+
+	// the LCIA methods of the package are written as impact categories of a
+	// single impact method
+	if len(e.categories) > 0 {
+		id := NameUUID("jsonld/method/" + packageName)
+		method := &olcaImpactMethod{
+			olcaEntity:       olcaEntity{Type: "ImpactMethod", ID: id, Name: packageName},
+			ImpactCategories: e.categories}
+		e.write("lcia_methods", id, method)
+	}
+	for _, folder := range []string{"unit_groups", "flow_properties", "flows",
+		"processes", "lcia_categories", "lcia_methods", "locations"} {
+		if e.counts[folder] > 0 {
+			log.Println(" ... wrote", e.counts[folder], folder)
+		}
+	}
+}
+
+// entity creates the common fields of an entity from the given data set.
+func entity(t string, doc *etree.Document) olcaEntity {
+	return olcaEntity{
+		Type:     t,
+		ID:       DataSetUUID(doc),
+		Name:     DataSetName(doc),
+		Category: strings.Join(ClassificationOf(doc), "/"),
+		Version:  DataSetVersion(doc)}
+}
+
+// unitID returns the ID of the unit with the given name in the given unit
+// group; units have no UUIDs in ILCD.
+func unitID(unitGroup, unit string) string {
+	return NameUUID("jsonld/unit/" + NormKey(unitGroup) + "/" + unit)
+}
+
+func (e *jsonldExport) unitGroup(doc *etree.Document) {
+	group := &olcaUnitGroup{olcaEntity: entity("UnitGroup", doc)}
+	refUnit := ReferenceUnit(doc)
+	for _, u := range doc.FindElements("./unitGroupDataSet/units/unit") {
+		name := childText(u, "./name")
+		unit := &olcaUnit{
+			Type:      "Unit",
+			ID:        unitID(group.ID, name),
+			Name:      name,
+			IsRefUnit: name == refUnit}
+		readNumber(u, "./meanValue", &unit.ConversionFactor)
+		group.Units = append(group.Units, unit)
+	}
+	e.write("unit_groups", group.ID, group)
+}
+
+func (e *jsonldExport) flowProperty(doc *etree.Document) {
+	prop := &olcaFlowProperty{
+		olcaEntity:       entity("FlowProperty", doc),
+		FlowPropertyType: "PHYSICAL_QUANTITY"}
+	ref := doc.FindElement(
+		"./flowPropertyDataSet/flowPropertiesInformation/quantitativeReference/referenceToReferenceUnitGroup")
+	if ref != nil {
+		prop.UnitGroup = &olcaRef{
+			Type: "UnitGroup",
+			ID:   strings.TrimSpace(ref.SelectAttrValue("refObjectId", "")),
+			Name: childText(ref, "./shortDescription")}
+	}
+	e.write("flow_properties", prop.ID, prop)
+}
+
+func (e *jsonldExport) flow(doc *etree.Document) {
+	flow := &olcaFlow{olcaEntity: entity("Flow", doc), FlowType: "PRODUCT_FLOW"}
+	switch childText(doc.Root(), "./modellingAndValidation/LCIMethod/typeOfDataSet") {
+	case "Elementary flow":
+		flow.FlowType = "ELEMENTARY_FLOW"
+	case "Waste flow":
+		flow.FlowType = "WASTE_FLOW"
+	}
+	flow.CAS = childText(doc.Root(), "./flowInformation/dataSetInformation/CASNumber")
+	flow.Formula = childText(doc.Root(), "./flowInformation/dataSetInformation/sumFormula")
+
+	refProp := ReferenceFlowProperty(doc)
+	for _, p := range doc.FindElements("./flowDataSet/flowProperties/flowProperty") {
+		ref := p.FindElement("./referenceToFlowPropertyDataSet")
+		if ref == nil {
+			continue
+		}
+		id := strings.TrimSpace(ref.SelectAttrValue("refObjectId", ""))
+		factor := &olcaFlowPropertyFactor{
+			Type: "FlowPropertyFactor",
+			FlowProperty: &olcaRef{
+				Type: "FlowProperty",
+				ID:   id,
+				Name: childText(ref, "./shortDescription")},
+			IsRefFlowProperty: id == refProp}
+		readNumber(p, "./meanValue", &factor.ConversionFactor)
+		flow.FlowProperties = append(flow.FlowProperties, factor)
+	}
+	e.write("flows", flow.ID, flow)
+}
+
+func (e *jsonldExport) process(doc *etree.Document) {
+	process := &olcaProcess{
+		olcaEntity:  entity("Process", doc),
+		ProcessType: "LCI_RESULT"}
+	if strings.HasPrefix(childText(doc.Root(),
+		"./modellingAndValidation/LCIMethodAndAllocation/typeOfDataSet"), "Unit process") {
+		process.ProcessType = "UNIT_PROCESS"
+	}
+	if geo := doc.FindElement(
+		"./processDataSet/processInformation/geography/locationOfOperationSupplyOrProduction"); geo != nil {
+		process.Location = e.location(geo.SelectAttrValue("location", ""))
+	}
+
+	refFlow := ReferenceFlowID(doc)
+	skipped := 0
+	for _, a := range ReadExchanges(doc) {
+		flow, prop, unit := e.flowRefs(a)
+		if flow == nil {
+			skipped++
+			continue
+		}
+		id, err := strconv.Atoi(a.InternalID)
+		if err != nil {
+			id = process.LastInternalID + 1
+		}
+		if id > process.LastInternalID {
+			process.LastInternalID = id
+		}
+		process.Exchanges = append(process.Exchanges, &olcaExchange{
+			Type:                    "Exchange",
+			InternalID:              id,
+			Amount:                  a.Amount,
+			IsInput:                 strings.EqualFold(a.Direction, "Input"),
+			IsQuantitativeReference: a.InternalID == refFlow,
+			Flow:                    flow,
+			FlowProperty:            prop,
+			Unit:                    unit,
+			Location:                e.location(a.Location)})
+	}
+	if skipped > 0 {
+		log.Println(" ... WARNING: skipped", skipped, "exchanges of process",
+			process.ID, "with flows that are not contained in the package")
+	}
+	e.write("processes", process.ID, process)
+}
+
+func (e *jsonldExport) impactCategory(doc *etree.Document) {
+	method := ReadMethodFactors(doc)
+	category := &olcaImpactCategory{
+		olcaEntity: entity("ImpactCategory", doc),
+		RefUnit:    method.Unit}
+	skipped := 0
+	for _, f := range ReadFactors(doc) {
+		flow, prop, unit := e.flowRefs(f)
+		if flow == nil {
+			skipped++
+			continue
+		}
+		category.ImpactFactors = append(category.ImpactFactors, &olcaImpactFactor{
+			Type:         "ImpactFactor",
+			Flow:         flow,
+			FlowProperty: prop,
+			Unit:         unit,
+			Location:     e.location(f.Location),
+			Value:        f.Amount})
+	}
+	if skipped > 0 {
+		log.Println(" ... WARNING: skipped", skipped, "factors of LCIA method",
+			category.ID, "with flows that are not contained in the package")
+	}
+	e.write("lcia_categories", category.ID, category)
+	e.categories = append(e.categories, &olcaRef{
+		Type: "ImpactCategory",
+		ID:   category.ID,
+		Name: category.Name})
+}
+
+// flowRefs returns the references to the flow, its reference flow property,
+// and the reference unit of the given exchange or factor. It returns nil
+// references if the flow is not contained in the package.
+func (e *jsonldExport) flowRefs(a *FlowAmount) (*olcaRef, *olcaRef, *olcaRef) {
+	info := e.flows.Get(a.FlowID)
+	if info == nil {
+		return nil, nil, nil
+	}
+	flow := &olcaRef{Type: "Flow", ID: info.UUID, Name: info.Name}
+	prop := &olcaRef{Type: "FlowProperty", ID: info.Property}
+	unit := &olcaRef{Type: "Unit", ID: unitID(info.UnitGroup, info.Unit),
+		Name: info.Unit}
+	return flow, prop, unit
+}
+
+// location returns the reference to the location with the given code and
+// writes the location if this was not done yet. It returns nil if the code
+// is empty.
+func (e *jsonldExport) location(code string) *olcaRef {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil
+	}
+	id := NameUUID("jsonld/location/" + strings.ToLower(code))
+	ref := &olcaRef{Type: "Location", ID: id, Name: code}
+	if !e.locations[id] {
+		e.locations[id] = true
+		e.write("locations", id, &olcaLocation{
+			olcaEntity: olcaEntity{Type: "Location", ID: id, Name: code},
+			Code:       code})
+	}
+	return ref
+}
+
+func (e *jsonldExport) write(folder, id string, entity interface{}) {
+	if id == "" {
+		log.Println(" ... ERROR: a data set in", folder, "has no UUID")
+		return
+	}
+	if e.writeJSON(folder+"/"+id+".json", entity) {
+		e.counts[folder]++
+	}
+}
+
+func (e *jsonldExport) writeJSON(path string, v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println(" ... ERROR: Failed to convert", path, err)
+		return false
+	}
+	if err := e.writer.Write(path, data); err != nil {
+		log.Println(" ... ERROR: Failed to write", path, err)
+		return false
+	}
+	return true
+}
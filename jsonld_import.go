@@ -0,0 +1,612 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// importJSONLD converts the openLCA JSON-LD packages in the working directory
+// into ILCD packages `<package>_ilcd.zip`. The packages have no `peflocus_`
+// prefix so that they can be passed to the other commands, e.g. to `unmap`.
+func importJSONLD(args *Args) {
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		if !isJSONLDPackage(path) {
+			continue
+		}
+		log.Println("INFO: Import JSON-LD package", path)
+		imp, err := readJSONLD(path)
+		if err != nil {
+			log.Println("ERROR: Failed to read JSON-LD package", path, err)
+			continue
+		}
+		target := strings.TrimSuffix(OutputPath(args.WorkDir, name, ""), ".zip") +
+			"_ilcd.zip"
+		DeleteExisting(target)
+		writer, err := ilcd.NewZipWriter(target)
+		if err != nil {
+			log.Println("ERROR: Failed to create zip writer for", target, ":", err)
+			continue
+		}
+		imp.writer = writer
+		imp.run()
+		writer.Close()
+		log.Println(" ... wrote ILCD package", target)
+	}
+}
+
+// isJSONLDPackage returns true if the given file is a zip file with openLCA
+// JSON-LD data sets.
+func isJSONLDPackage(path string) bool {
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return false
+	}
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name == "olca-schema.json" ||
+			(strings.HasPrefix(f.Name, "flows/") && strings.HasSuffix(f.Name, ".json")) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonldImport converts the entities of a JSON-LD package into ILCD data
+// sets.
+type jsonldImport struct {
+	writer *ilcd.ZipWriter
+
+	unitGroups []*olcaUnitGroup
+	properties []*olcaFlowProperty
+	flows      []*olcaFlow
+	processes  []*olcaProcess
+	categories []*olcaImpactCategory
+
+	// location ID -> location code
+	locations map[string]string
+
+	// unit ID -> conversion factor to the reference unit of its group
+	units map[string]float64
+
+	// flow ID -> flow
+	flowIndex map[string]*olcaFlow
+
+	// the UUIDs of the generated reference quantities of LCIA methods by
+	// their unit
+	quantities map[string]string
+}
+
+// readJSONLD reads the entities of the JSON-LD package.
+func readJSONLD(file string) (*jsonldImport, error) {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	imp := &jsonldImport{
+		locations:  make(map[string]string),
+		units:      make(map[string]float64),
+		flowIndex:  make(map[string]*olcaFlow),
+		quantities: make(map[string]string)}
+
+	// the categories of olca-schema version 1 packages; version 2 packages
+	// have no category entities
+	categories := make(map[string]*olcaCategoryRef)
+	for _, f := range r.File {
+		if path.Dir(f.Name) != "categories" || !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		category := &olcaCategoryRef{}
+		if err := readJSONEntry(f, category, nil); err != nil {
+			log.Println(" ... ERROR: Failed to read", f.Name, err)
+			continue
+		}
+		categories[category.ID] = category
+	}
+
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		var target interface{}
+		switch path.Dir(f.Name) {
+		case "unit_groups":
+			group := &olcaUnitGroup{}
+			imp.unitGroups = append(imp.unitGroups, group)
+			target = group
+		case "flow_properties":
+			prop := &olcaFlowProperty{}
+			imp.properties = append(imp.properties, prop)
+			target = prop
+		case "flows":
+			flow := &olcaFlow{}
+			imp.flows = append(imp.flows, flow)
+			target = flow
+		case "processes":
+			process := &olcaProcess{}
+			imp.processes = append(imp.processes, process)
+			target = process
+		case "lcia_categories":
+			category := &olcaImpactCategory{}
+			imp.categories = append(imp.categories, category)
+			target = category
+		case "locations":
+			target = &olcaLocation{}
+		default:
+			continue
+		}
+		if err := readJSONEntry(f, target, categories); err != nil {
+			log.Println(" ... ERROR: Failed to read", f.Name, err)
+			continue
+		}
+		if location, ok := target.(*olcaLocation); ok {
+			imp.locations[location.ID] = location.Code
+		}
+	}
+
+	for _, group := range imp.unitGroups {
+		for _, unit := range group.Units {
+			imp.units[unit.ID] = unit.ConversionFactor
+		}
+	}
+	for _, flow := range imp.flows {
+		imp.flowIndex[flow.ID] = flow
+	}
+	return imp, nil
+}
+
+// readJSONEntry reads the given zip entry into v. If the entry has a category
+// reference of olca-schema version 1, the reference is replaced by the path of
+// the category as in version 2; see `olcaCategoryRef`.
+func readJSONEntry(f *zip.File, v interface{}, categories map[string]*olcaCategoryRef) error {
+	reader, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if categories != nil {
+		if data, err = replaceCategoryRef(data, categories); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// olcaCategoryRef is a category or a reference to a category in version 1
+// of the olca-schema. In version 2, the category of an entity is just its
+// path, e.g. `Elementary flows/Emission to air`.
+type olcaCategoryRef struct {
+	ID   string `json:"@id"`
+	Name string `json:"name"`
+
+	// the path of the parent category; only given in references
+	CategoryPath []string `json:"categoryPath"`
+
+	// the parent category; only given in category entities
+	Category json.RawMessage `json:"category"`
+}
+
+// replaceCategoryRef replaces a category reference of olca-schema version 1
+// in the given entity by the path of the category.
+func replaceCategoryRef(data []byte, categories map[string]*olcaCategoryRef) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	raw := fields["category"]
+	if !isJSONObject(raw) {
+		return data, nil
+	}
+	ref := &olcaCategoryRef{}
+	if err := json.Unmarshal(raw, ref); err != nil {
+		return nil, err
+	}
+	path, err := json.Marshal(categoryPath(ref, categories, 0))
+	if err != nil {
+		return nil, err
+	}
+	fields["category"] = path
+	return json.Marshal(fields)
+}
+
+// categoryPath returns the path of the given category reference. The path is
+// taken from the category entities of the package or, if the category is not
+// contained in the package, from the reference.
+func categoryPath(ref *olcaCategoryRef, categories map[string]*olcaCategoryRef,
+	depth int) string {
+	category := categories[ref.ID]
+	if category == nil || depth > 32 {
+		return strings.Join(append(ref.CategoryPath, ref.Name), "/")
+	}
+	var parent string
+	if isJSONObject(category.Category) {
+		parentRef := &olcaCategoryRef{}
+		if json.Unmarshal(category.Category, parentRef) == nil {
+			parent = categoryPath(parentRef, categories, depth+1)
+		}
+	}
+	if parent == "" {
+		return category.Name
+	}
+	return parent + "/" + category.Name
+}
+
+func isJSONObject(raw json.RawMessage) bool {
+	s := strings.TrimSpace(string(raw))
+	return strings.HasPrefix(s, "{")
+}
+
+func (imp *jsonldImport) run() {
+	for _, group := range imp.unitGroups {
+		imp.write(ilcd.UnitGroupDataSet, group.ID, imp.unitGroup(group))
+	}
+	for _, prop := range imp.properties {
+		imp.write(ilcd.FlowPropertyDataSet, prop.ID, imp.flowProperty(prop))
+	}
+	for _, flow := range imp.flows {
+		imp.write(ilcd.FlowDataSet, flow.ID, imp.flow(flow))
+	}
+	for _, process := range imp.processes {
+		imp.write(ilcd.ProcessDataSet, process.ID, imp.process(process))
+	}
+	for _, category := range imp.categories {
+		imp.write(ilcd.MethodDataSet, category.ID, imp.method(category))
+	}
+	log.Println(" ... converted", len(imp.unitGroups), "unit groups,",
+		len(imp.properties), "flow properties,", len(imp.flows), "flows,",
+		len(imp.processes), "processes, and", len(imp.categories), "LCIA methods")
+}
+
+func (imp *jsonldImport) write(t ilcd.DataSetType, id string, doc *etree.Document) {
+	if id == "" {
+		log.Println(" ... ERROR: an entity in", t.Folder(), "has no ID")
+		return
+	}
+	doc.Indent(2)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		log.Println(" ... ERROR: Failed to convert", t.Folder(), id, err)
+		return
+	}
+	entry := "ILCD/" + t.Folder() + "/" + id + ".xml"
+	if err := imp.writer.Write(entry, data); err != nil {
+		log.Println(" ... ERROR: Failed to write", entry, err)
+	}
+}
+
+// newDataSet creates a new ILCD data set document with the given root
+// element and namespace.
+func newDataSet(root, namespace string) (*etree.Document, *etree.Element) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	elem := doc.CreateElement(root)
+	elem.CreateAttr("xmlns", namespace)
+	elem.CreateAttr("xmlns:common", "http://lca.jrc.it/ILCD/Common")
+	elem.CreateAttr("version", "1.1")
+	return doc, elem
+}
+
+// addDataSetInfo adds the UUID, name, and classification of the given entity
+// to the `dataSetInformation` element. The name is added as `common:name`
+// or, if `baseName` is true, as structured name with a base name.
+func addDataSetInfo(parent *etree.Element, e *olcaEntity, baseName bool) *etree.Element {
+	info := parent.CreateElement("dataSetInformation")
+	info.CreateElement("common:UUID").SetText(e.ID)
+	var name *etree.Element
+	if baseName {
+		name = info.CreateElement("name").CreateElement("baseName")
+	} else {
+		name = info.CreateElement("common:name")
+	}
+	name.CreateAttr("xml:lang", "en")
+	name.SetText(e.Name)
+	if e.Category != "" {
+		classification := info.CreateElement("classificationInformation").
+			CreateElement("common:classification")
+		for i, class := range strings.Split(e.Category, "/") {
+			c := classification.CreateElement("common:class")
+			c.CreateAttr("level", strconv.Itoa(i))
+			c.SetText(class)
+		}
+	}
+	return info
+}
+
+// addAdminInfo adds the administrative information with the version of the
+// given entity.
+func addAdminInfo(parent *etree.Element, e *olcaEntity) {
+	version := e.Version
+	if version == "" {
+		version = "01.00.000"
+	}
+	parent.CreateElement("administrativeInformation").
+		CreateElement("publicationAndOwnership").
+		CreateElement("common:dataSetVersion").SetText(version)
+}
+
+// addRef adds a data set reference with the given tag.
+func addRef(parent *etree.Element, tag string, t ilcd.DataSetType, refType, id,
+	name string) *etree.Element {
+	ref := parent.CreateElement(tag)
+	ref.CreateAttr("type", refType)
+	ref.CreateAttr("refObjectId", id)
+	ref.CreateAttr("uri", "../"+t.Folder()+"/"+id+".xml")
+	if name != "" {
+		desc := ref.CreateElement("common:shortDescription")
+		desc.CreateAttr("xml:lang", "en")
+		desc.SetText(name)
+	}
+	return ref
+}
+
+func formatNumber(val float64) string {
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+func (imp *jsonldImport) unitGroup(group *olcaUnitGroup) *etree.Document {
+	doc, root := newDataSet("unitGroupDataSet", "http://lca.jrc.it/ILCD/UnitGroup")
+	info := root.CreateElement("unitGroupInformation")
+	addDataSetInfo(info, &group.olcaEntity, false)
+	refUnit := info.CreateElement("quantitativeReference").
+		CreateElement("referenceToReferenceUnit")
+	addAdminInfo(root, &group.olcaEntity)
+	units := root.CreateElement("units")
+	for i, u := range group.Units {
+		unit := units.CreateElement("unit")
+		unit.CreateAttr("dataSetInternalID", strconv.Itoa(i))
+		unit.CreateElement("name").SetText(u.Name)
+		unit.CreateElement("meanValue").SetText(formatNumber(u.ConversionFactor))
+		if u.IsRefUnit {
+			refUnit.SetText(strconv.Itoa(i))
+		}
+	}
+	return doc
+}
+
+func (imp *jsonldImport) flowProperty(prop *olcaFlowProperty) *etree.Document {
+	doc, root := newDataSet("flowPropertyDataSet", "http://lca.jrc.it/ILCD/FlowProperty")
+	info := root.CreateElement("flowPropertiesInformation")
+	addDataSetInfo(info, &prop.olcaEntity, false)
+	if prop.UnitGroup != nil {
+		addRef(info.CreateElement("quantitativeReference"),
+			"referenceToReferenceUnitGroup", ilcd.UnitGroupDataSet,
+			"unit group data set", prop.UnitGroup.ID, prop.UnitGroup.Name)
+	}
+	addAdminInfo(root, &prop.olcaEntity)
+	return doc
+}
+
+func (imp *jsonldImport) flow(flow *olcaFlow) *etree.Document {
+	doc, root := newDataSet("flowDataSet", "http://lca.jrc.it/ILCD/Flow")
+	info := root.CreateElement("flowInformation")
+	dataInfo := addDataSetInfo(info, &flow.olcaEntity, true)
+	if flow.CAS != "" {
+		dataInfo.CreateElement("CASNumber").SetText(flow.CAS)
+	}
+	if flow.Formula != "" {
+		dataInfo.CreateElement("sumFormula").SetText(flow.Formula)
+	}
+	refProp := info.CreateElement("quantitativeReference").
+		CreateElement("referenceToReferenceFlowProperty")
+
+	flowType := "Product flow"
+	switch flow.FlowType {
+	case "ELEMENTARY_FLOW":
+		flowType = "Elementary flow"
+		// elementary flows are categorized by an elementary flow
+		// categorization instead of a classification
+		if c := dataInfo.FindElement("./classificationInformation/classification"); c != nil {
+			c.Space, c.Tag = "common", "elementaryFlowCategorization"
+			for _, class := range c.ChildElements() {
+				class.Tag = "category"
+			}
+		}
+	case "WASTE_FLOW":
+		flowType = "Waste flow"
+	}
+	root.CreateElement("modellingAndValidation").
+		CreateElement("LCIMethod").
+		CreateElement("typeOfDataSet").SetText(flowType)
+	addAdminInfo(root, &flow.olcaEntity)
+
+	props := root.CreateElement("flowProperties")
+	for i, f := range flow.FlowProperties {
+		if f.FlowProperty == nil {
+			continue
+		}
+		prop := props.CreateElement("flowProperty")
+		prop.CreateAttr("dataSetInternalID", strconv.Itoa(i))
+		addRef(prop, "referenceToFlowPropertyDataSet", ilcd.FlowPropertyDataSet,
+			"flow property data set", f.FlowProperty.ID, f.FlowProperty.Name)
+		prop.CreateElement("meanValue").SetText(formatNumber(f.ConversionFactor))
+		if f.IsRefFlowProperty {
+			refProp.SetText(strconv.Itoa(i))
+		}
+	}
+	return doc
+}
+
+// refAmount converts the given amount in the given unit and flow property of
+// the flow into the reference unit of the reference flow property.
+func (imp *jsonldImport) refAmount(amount float64, flow, prop, unit *olcaRef) float64 {
+	if unit != nil {
+		if factor, ok := imp.units[unit.ID]; ok && factor != 0 {
+			amount *= factor
+		}
+	}
+	if f := imp.flowIndex[refID(flow)]; f != nil && prop != nil {
+		for _, factor := range f.FlowProperties {
+			if factor.FlowProperty != nil && factor.FlowProperty.ID == prop.ID &&
+				factor.ConversionFactor != 0 {
+				amount /= factor.ConversionFactor
+				break
+			}
+		}
+	}
+	return amount
+}
+
+func refID(ref *olcaRef) string {
+	if ref == nil {
+		return ""
+	}
+	return ref.ID
+}
+
+// locationCode returns the code of the referenced location.
+func (imp *jsonldImport) locationCode(ref *olcaRef) string {
+	if ref == nil {
+		return ""
+	}
+	if code := imp.locations[ref.ID]; code != "" {
+		return code
+	}
+	return ref.Name
+}
+
+func (imp *jsonldImport) process(process *olcaProcess) *etree.Document {
+	doc, root := newDataSet("processDataSet", "http://lca.jrc.it/ILCD/Process")
+	info := root.CreateElement("processInformation")
+	addDataSetInfo(info, &process.olcaEntity, true)
+	qRef := info.CreateElement("quantitativeReference")
+	qRef.CreateAttr("type", "Reference flow(s)")
+	refFlow := qRef.CreateElement("referenceToReferenceFlow")
+	if code := imp.locationCode(process.Location); code != "" {
+		info.CreateElement("geography").
+			CreateElement("locationOfOperationSupplyOrProduction").
+			CreateAttr("location", code)
+	}
+
+	processType := "LCI result"
+	if process.ProcessType == "UNIT_PROCESS" {
+		processType = "Unit process, single operation"
+	}
+	root.CreateElement("modellingAndValidation").
+		CreateElement("LCIMethodAndAllocation").
+		CreateElement("typeOfDataSet").SetText(processType)
+	addAdminInfo(root, &process.olcaEntity)
+
+	exchanges := root.CreateElement("exchanges")
+	for _, e := range process.Exchanges {
+		if e.Flow == nil {
+			continue
+		}
+		direction := "Output"
+		if e.IsInput {
+			direction = "Input"
+		}
+		addExchange(exchanges, e.InternalID, &FlowAmount{
+			FlowID:    e.Flow.ID,
+			FlowName:  e.Flow.Name,
+			Direction: direction,
+			Location:  imp.locationCode(e.Location),
+			Amount:    imp.refAmount(e.Amount, e.Flow, e.FlowProperty, e.Unit)})
+		if e.IsQuantitativeReference {
+			refFlow.SetText(strconv.Itoa(e.InternalID))
+		}
+	}
+	return doc
+}
+
+func (imp *jsonldImport) method(category *olcaImpactCategory) *etree.Document {
+	doc, root := newDataSet("LCIAMethodDataSet", "http://lca.jrc.it/ILCD/LCIAMethod")
+	info := root.CreateElement("LCIAMethodInformation")
+	addDataSetInfo(info, &category.olcaEntity, false)
+	if category.RefUnit != "" {
+		addRef(info.CreateElement("quantitativeReference"), "referenceQuantity",
+			ilcd.FlowPropertyDataSet, "flow property data set",
+			imp.quantity(category.RefUnit), category.RefUnit)
+	}
+	addAdminInfo(root, &category.olcaEntity)
+
+	factors := root.CreateElement("characterisationFactors")
+	for _, f := range category.ImpactFactors {
+		if f.Flow == nil {
+			continue
+		}
+		factor := factors.CreateElement("factor")
+		addRef(factor, "referenceToFlowDataSet", ilcd.FlowDataSet,
+			"flow data set", f.Flow.ID, f.Flow.Name)
+		if code := imp.locationCode(f.Location); code != "" {
+			factor.CreateElement("location").SetText(code)
+		}
+		factor.CreateElement("exchangeDirection").SetText(imp.factorDirection(f.Flow))
+
+		// the factor is given per unit of the flow; thus, the conversion is
+		// the inverse of the conversion of amounts
+		value := f.Value
+		if perRef := imp.refAmount(1, f.Flow, f.FlowProperty, f.Unit); perRef != 0 {
+			value /= perRef
+		}
+		factor.CreateElement("meanValue").SetText(formatNumber(value))
+	}
+	return doc
+}
+
+// factorDirection returns the ILCD direction of a characterization factor:
+// `Input` for resources and `Output` for all other elementary flows. A flow is
+// a resource when a segment of its category path is `resource` or `resources`,
+// e.g. `Elementary flows/Resource/in ground`.
+func (imp *jsonldImport) factorDirection(ref *olcaRef) string {
+	if flow := imp.flowIndex[ref.ID]; flow != nil && isResourceCategory(flow.Category) {
+		return "Input"
+	}
+	return "Output"
+}
+
+// isResourceCategory returns true if the given category path contains a
+// `resource` or `resources` segment.
+func isResourceCategory(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		switch strings.ToLower(strings.TrimSpace(segment)) {
+		case "resource", "resources":
+			return true
+		}
+	}
+	return false
+}
+
+// quantity returns the UUID of the flow property that is the reference
+// quantity of LCIA methods with the given unit. The flow property and a unit
+// group with that unit are written with the first call for a unit.
+func (imp *jsonldImport) quantity(unit string) string {
+	if id, ok := imp.quantities[unit]; ok {
+		return id
+	}
+	groupID := NameUUID("jsonld/impact-unit-group/" + unit)
+	group := &olcaUnitGroup{
+		olcaEntity: olcaEntity{Type: "UnitGroup", ID: groupID, Name: "Units of " + unit},
+		Units: []*olcaUnit{{
+			Type:             "Unit",
+			ID:               unitID(groupID, unit),
+			Name:             unit,
+			ConversionFactor: 1,
+			IsRefUnit:        true}}}
+	imp.write(ilcd.UnitGroupDataSet, groupID, imp.unitGroup(group))
+
+	id := NameUUID("jsonld/impact-quantity/" + unit)
+	prop := &olcaFlowProperty{
+		olcaEntity: olcaEntity{Type: "FlowProperty", ID: id, Name: unit},
+		UnitGroup:  &olcaRef{Type: "UnitGroup", ID: groupID, Name: group.Name}}
+	imp.write(ilcd.FlowPropertyDataSet, id, imp.flowProperty(prop))
+	imp.quantities[unit] = id
+	return id
+}
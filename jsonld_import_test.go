@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIsResourceCategory(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"Resource/in ground", true},
+		{"Elementary flows/Resource/in ground", true},
+		{"Elementary flows/resources/biotic", true},
+		{"Elementary flows/Emission to air", false},
+		{"Resource use", false},
+		{"Elementary flows/Resources from ground", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isResourceCategory(test.path); got != test.want {
+			t.Errorf("isResourceCategory(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestReplaceCategoryRef(t *testing.T) {
+	// the categories of an olca-schema version 1 package
+	categories := map[string]*olcaCategoryRef{
+		"c1": {ID: "c1", Name: "Elementary flows"},
+		"c2": {ID: "c2", Name: "Resource", Category: []byte(`{"@id": "c1"}`)},
+		"c3": {ID: "c3", Name: "in ground", Category: []byte(`{"@id": "c2"}`)},
+	}
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"@id": "f", "category": {"@type": "Category", "@id": "c3"}}`,
+			"Elementary flows/Resource/in ground"},
+		{`{"@id": "f", "category": {"@id": "x", "name": "in air", "categoryPath": ["Emissions"]}}`,
+			"Emissions/in air"},
+		{`{"@id": "f", "category": "Elementary flows/Emission to air"}`,
+			"Elementary flows/Emission to air"},
+		{`{"@id": "f"}`, ""},
+	}
+	for _, test := range tests {
+		data, err := replaceCategoryRef([]byte(test.json), categories)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e olcaEntity
+		if err := json.Unmarshal(data, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != "f" || e.Category != test.want {
+			t.Errorf("got category %q from %s, want %q", e.Category, test.json, test.want)
+		}
+	}
+}
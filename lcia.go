@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// The kinds of how an exchange is matched with a characterization factor.
+const (
+	// matchNone means that no factor was found for the exchange
+	matchNone = iota
+
+	// matchExact means that a factor with the same flow, direction, and
+	// location was found
+	matchExact
+
+	// matchFallback means that the exchange has a location but there is no
+	// factor for that location; thus, the unregionalized factor was used
+	matchFallback
+
+	// matchGlobal means that there is neither a factor for the location of
+	// the exchange nor an unregionalized factor; thus, the factor for `GLO`
+	// was used
+	matchGlobal
+)
+
+// MethodFactors contains the characterization factors of an LCIA method.
+type MethodFactors struct {
+	UUID string
+	Name string
+	Unit string
+
+	// <flow uuid>/<direction> -> location -> factor; the empty location
+	// contains the unregionalized factor
+	factors map[string]map[string]float64
+}
+
+// ReadMethodFactors reads the characterization factors of the given LCIA
+// method data set.
+func ReadMethodFactors(doc *etree.Document) *MethodFactors {
+	m := &MethodFactors{
+		UUID:    DataSetUUID(doc),
+		Name:    DataSetName(doc),
+		factors: make(map[string]map[string]float64)}
+	ref := doc.FindElement(
+		"./LCIAMethodDataSet/LCIAMethodInformation/quantitativeReference/referenceQuantity")
+	if ref != nil {
+		m.Unit = childText(ref, "./shortDescription")
+	}
+	for _, f := range ReadFactors(doc) {
+		key := factorKey(f.FlowID, f.Direction)
+		locations := m.factors[key]
+		if locations == nil {
+			locations = make(map[string]float64)
+			m.factors[key] = locations
+		}
+		locations[NormKey(f.Location)] = f.Amount
+	}
+	return m
+}
+
+func factorKey(flowID, direction string) string {
+	return NormKey(flowID) + "/" + NormKey(direction)
+}
+
+// Characterizes returns true if the method has at least one factor for the
+// given flow and direction.
+func (m *MethodFactors) Characterizes(flowID, direction string) bool {
+	return m.factors[factorKey(flowID, direction)] != nil
+}
+
+// Match returns the characterization factor for the given exchange and how
+// it was matched. A factor with the location of the exchange is preferred.
+// If there is no such factor, the unregionalized factor and then the factor
+// for `GLO` is used.
+func (m *MethodFactors) Match(e *FlowAmount) (float64, int) {
+	locations := m.factors[factorKey(e.FlowID, e.Direction)]
+	if locations == nil {
+		return 0, matchNone
+	}
+	location := NormKey(e.Location)
+	if factor, ok := locations[location]; ok {
+		return factor, matchExact
+	}
+	if factor, ok := locations[""]; ok {
+		return factor, matchFallback
+	}
+	if factor, ok := locations["glo"]; ok {
+		return factor, matchGlobal
+	}
+	return 0, matchNone
+}
+
+// ImpactResult is the result of an LCIA method for a process or model.
+type ImpactResult struct {
+	Type       string  `json:"type"`
+	UUID       string  `json:"uuid"`
+	Name       string  `json:"name"`
+	Method     string  `json:"method"`
+	MethodName string  `json:"methodName"`
+	Result     float64 `json:"result"`
+	Unit       string  `json:"unit,omitempty"`
+
+	// the number of exchanges for which the unregionalized factor or the
+	// factor for `GLO` was used
+	Fallbacks int `json:"fallbacks"`
+
+	// the exchanges of characterized flows for which no factor was found;
+	// these exchanges are not included in the result
+	Misses []*FactorMiss `json:"misses,omitempty"`
+}
+
+// FactorMiss is an exchange of a characterized flow for which no factor with
+// a matching location, no unregionalized factor, and no factor for `GLO` was
+// found.
+type FactorMiss struct {
+	FlowID    string  `json:"flow"`
+	FlowName  string  `json:"flowName,omitempty"`
+	Direction string  `json:"direction"`
+	Location  string  `json:"location,omitempty"`
+	Amount    float64 `json:"amount"`
+}
+
+// Calculate calculates the impact result of the given inventory.
+func (m *MethodFactors) Calculate(inventory []*FlowAmount) *ImpactResult {
+	r := &ImpactResult{Method: m.UUID, MethodName: m.Name, Unit: m.Unit}
+	for _, e := range inventory {
+		factor, match := m.Match(e)
+		switch match {
+		case matchNone:
+			if m.Characterizes(e.FlowID, e.Direction) {
+				r.Misses = append(r.Misses, &FactorMiss{
+					FlowID:    e.FlowID,
+					FlowName:  e.FlowName,
+					Direction: e.Direction,
+					Location:  e.Location,
+					Amount:    e.Amount})
+			}
+			continue
+		case matchFallback, matchGlobal:
+			r.Fallbacks++
+		}
+		r.Result += factor * e.Amount
+	}
+	return r
+}
+
+// readMethods reads the LCIA methods of the given package. If the given set
+// of UUIDs is not nil, only these methods are returned.
+func readMethods(reader *ilcd.ZipReader, uuids map[string]bool) []*MethodFactors {
+	var methods []*MethodFactors
+	eachMethodDoc(reader, func(doc *etree.Document) {
+		m := ReadMethodFactors(doc)
+		if uuids == nil || uuids[NormKey(m.UUID)] {
+			methods = append(methods, m)
+		}
+	})
+	return methods
+}
+
+// eachMethodDoc calls the given function for each LCIA method data set of the
+// given package.
+func eachMethodDoc(reader *ilcd.ZipReader, fn func(doc *etree.Document)) {
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		if zipFile.Type() != ilcd.MethodDataSet ||
+			!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+			return true
+		}
+		data, err := zipFile.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read LCIA method", zipFile.Path(), err)
+			return true
+		}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(data); err != nil {
+			log.Println("ERROR: Failed to parse LCIA method", zipFile.Path(), err)
+			return true
+		}
+		fn(doc)
+		return true
+	})
+}
+
+// lciaCommand calculates the impact results of the processes or life cycle
+// models of the packages in the working directory.
+func lciaCommand(args *Args) {
+	var methodIDs, uuids map[string]bool
+	if args.Methods != "" {
+		methodIDs = readUUIDs(args.Methods)
+	}
+	if args.UUIDs != "" {
+		uuids = readUUIDs(args.UUIDs)
+	}
+	forModels := false
+	if args.Type != "" {
+		switch ParseDataSetType(args.Type) {
+		case ilcd.ProcessDataSet:
+		case ilcd.ModelDataSet:
+			forModels = true
+		default:
+			log.Fatalln("ERROR: LCIA results can be only calculated for",
+				"processes or models")
+		}
+	}
+	format := strings.ToLower(args.Format)
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		log.Fatalln("ERROR: Unknown format", args.Format)
+	}
+
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		methods := readMethods(reader.ZipReader, methodIDs)
+		log.Println("INFO: calculate LCIA results in", path, "with",
+			len(methods), "LCIA methods")
+		var results []*ImpactResult
+		add := func(t ilcd.DataSetType, uuid, name string, inventory []*FlowAmount) {
+			for _, m := range methods {
+				r := m.Calculate(inventory)
+				r.Type = t.Folder()
+				r.UUID = uuid
+				r.Name = name
+				results = append(results, r)
+				for _, e := range r.Misses {
+					log.Println(" ... WARNING: no factor in", m.Name, "for flow",
+						e.FlowID, "("+e.Direction+") in location", "'"+e.Location+"'",
+						"of", t.Folder(), uuid+"; the exchange is not characterized")
+				}
+			}
+		}
+		if len(methods) > 0 && forModels {
+			flows := NewFlowIndex(reader.ZipReader)
+			reader.EachModel(func(model *ilcd.Model) bool {
+				if uuids != nil && !uuids[NormKey(model.UUID())] {
+					return true
+				}
+				result, err := CalcModel(model, reader.ZipReader, flows)
+				if err != nil {
+					log.Println("ERROR: Failed to calculate model", model.UUID(), err)
+					return true
+				}
+				add(ilcd.ModelDataSet, model.UUID(), model.FullName("en"),
+					result.Inventory)
+				return true
+			})
+		} else if len(methods) > 0 {
+			eachProcessDoc(reader.ZipReader, func(doc *etree.Document) {
+				uuid := DataSetUUID(doc)
+				if uuids != nil && !uuids[NormKey(uuid)] {
+					return
+				}
+				add(ilcd.ProcessDataSet, uuid, DataSetName(doc), ReadExchanges(doc))
+			})
+		}
+		target := strings.TrimSuffix(
+			OutputPath(args.WorkDir, name, "peflocus_lcia_"), ".zip") + "." + format
+		writeImpactResults(results, target, format)
+		reader.Close()
+	}
+}
+
+// eachProcessDoc calls the given function for each process data set of the
+// given package.
+func eachProcessDoc(reader *ilcd.ZipReader, fn func(doc *etree.Document)) {
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		if zipFile.Type() != ilcd.ProcessDataSet ||
+			!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+			return true
+		}
+		data, err := zipFile.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read process", zipFile.Path(), err)
+			return true
+		}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(data); err != nil {
+			log.Println("ERROR: Failed to parse process", zipFile.Path(), err)
+			return true
+		}
+		fn(doc)
+		return true
+	})
+}
+
+func writeImpactResults(results []*ImpactResult, path, format string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Println("ERROR: Failed to create file", path, err)
+		return
+	}
+	defer f.Close()
+	if format == "json" {
+		if results == nil {
+			results = []*ImpactResult{}
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(results)
+	} else {
+		writer := csv.NewWriter(f)
+		writer.Write([]string{"Type", "UUID", "Name", "Method UUID", "Method",
+			"Result", "Unit", "Fallbacks", "Misses"})
+		for _, r := range results {
+			writer.Write([]string{r.Type, r.UUID, r.Name, r.Method, r.MethodName,
+				strconv.FormatFloat(r.Result, 'g', -1, 64), r.Unit,
+				strconv.Itoa(r.Fallbacks), strconv.Itoa(len(r.Misses))})
+		}
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		log.Println("ERROR: Failed to write LCIA results", path, err)
+		return
+	}
+	log.Println(" ... wrote", len(results), "results to", path)
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	m := &MethodFactors{factors: map[string]map[string]float64{
+		factorKey("f1", "Output"): {"pl": 1, "": 2, "glo": 3},
+		factorKey("f2", "Output"): {"pl": 1, "glo": 3},
+		factorKey("f3", "Output"): {"pl": 1},
+	}}
+	tests := []struct {
+		flow, location string
+		factor         float64
+		match          int
+	}{
+		{"f1", "PL", 1, matchExact},
+		{"f1", "DE", 2, matchFallback},
+		{"f1", "", 2, matchExact},
+		{"f2", "DE", 3, matchGlobal},
+		{"f2", "", 3, matchGlobal},
+		{"f2", "GLO", 3, matchExact},
+		{"f3", "DE", 0, matchNone},
+		{"f4", "PL", 0, matchNone},
+	}
+	for _, test := range tests {
+		e := &FlowAmount{FlowID: test.flow, Direction: "Output",
+			Location: test.location}
+		factor, match := m.Match(e)
+		if factor != test.factor || match != test.match {
+			t.Errorf("Match(%s, %s) = %v, %d; want %v, %d", test.flow,
+				test.location, factor, match, test.factor, test.match)
+		}
+	}
+}
+
+func TestCalculateReportsMisses(t *testing.T) {
+	m := &MethodFactors{factors: map[string]map[string]float64{
+		factorKey("f1", "Output"): {"pl": 2},
+	}}
+	r := m.Calculate([]*FlowAmount{
+		{FlowID: "f1", Direction: "Output", Location: "PL", Amount: 1},
+		{FlowID: "f1", Direction: "Output", Location: "DE", Amount: 1},
+		{FlowID: "f2", Direction: "Output", Location: "DE", Amount: 1},
+	})
+	if r.Result != 2 {
+		t.Errorf("got result %v, want 2", r.Result)
+	}
+	if len(r.Misses) != 1 || r.Misses[0].Location != "DE" {
+		t.Errorf("got misses %v, want the exchange in DE", r.Misses)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/msrocka/ilcd"
+)
+
+// DataSetFinder finds the data set of a given type and UUID. An
+// `ilcd.ZipReader` is a finder that searches a single package.
+type DataSetFinder interface {
+	FindDataSet(t ilcd.DataSetType, uuid string) *ilcd.ZipFile
+}
+
+// Library is a set of additional packages that are searched for data sets
+// that are not contained in the package that is processed, e.g. background
+// processes that are distributed in a separate package.
+type Library struct {
+	readers []*PackageReader
+}
+
+// OpenLibrary opens the packages of the given comma separated list of paths.
+// A path can be a zip file, a package folder, or a folder that contains
+// packages. Paths that cannot be read are logged and skipped.
+func OpenLibrary(paths string) *Library {
+	lib := &Library{}
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println("ERROR: Could not read library", path, err)
+			continue
+		}
+		if !info.IsDir() || IsPackageFolder(path) {
+			lib.add(path)
+			continue
+		}
+		for _, name := range GetPackageNames(path, false) {
+			lib.add(filepath.Join(path, name))
+		}
+	}
+	log.Println("INFO: Opened", len(lib.readers), "library packages")
+	return lib
+}
+
+func (lib *Library) add(path string) {
+	reader, err := OpenPackage(path)
+	if err != nil {
+		log.Println("ERROR: Could not read library package", path, err)
+		return
+	}
+	lib.readers = append(lib.readers, reader)
+}
+
+// Close closes the packages of the library.
+func (lib *Library) Close() {
+	for _, reader := range lib.readers {
+		reader.Close()
+	}
+}
+
+// With returns a finder that first searches the given package and then the
+// packages of the library.
+func (lib *Library) With(reader *ilcd.ZipReader) DataSetFinder {
+	if lib == nil || len(lib.readers) == 0 {
+		return reader
+	}
+	return &libraryFinder{reader: reader, lib: lib}
+}
+
+type libraryFinder struct {
+	reader *ilcd.ZipReader
+	lib    *Library
+}
+
+func (f *libraryFinder) FindDataSet(t ilcd.DataSetType, uuid string) *ilcd.ZipFile {
+	if zipFile := f.reader.FindDataSet(t, uuid); zipFile != nil {
+		return zipFile
+	}
+	for _, reader := range f.lib.readers {
+		if zipFile := reader.FindDataSet(t, uuid); zipFile != nil {
+			return zipFile
+		}
+	}
+	return nil
+}
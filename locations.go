@@ -0,0 +1,84 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+)
+
+//go:embed ILCDLocations.xml
+var ilcdLocationsXML []byte
+
+// LocationRegistry contains the known location codes of the ILCD format
+// (ISO 3166-1 country codes and regional codes like `GLO` or `RER`) with
+// their names.
+type LocationRegistry struct {
+	// normalized code -> location
+	locations map[string]*Location
+}
+
+// Location is a location of the registry.
+type Location struct {
+	Code string
+	Name string
+}
+
+var (
+	registry     *LocationRegistry
+	registryOnce sync.Once
+)
+
+// Locations returns the location registry that is loaded from the embedded
+// `ILCDLocations.xml` file.
+func Locations() *LocationRegistry {
+	registryOnce.Do(func() {
+		registry = &LocationRegistry{locations: make(map[string]*Location)}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(ilcdLocationsXML); err != nil {
+			log.Println("ERROR: Failed to read the ILCD location list", err)
+			return
+		}
+		for _, elem := range doc.FindElements("./ILCDLocations/location") {
+			code := strings.TrimSpace(elem.SelectAttrValue("value", ""))
+			if code == "" {
+				continue
+			}
+			registry.locations[NormKey(code)] = &Location{
+				Code: code,
+				Name: strings.TrimSpace(elem.Text())}
+		}
+	})
+	return registry
+}
+
+// Get returns the location with the given code. The code is matched case
+// insensitive. It returns nil if the code is not known.
+func (r *LocationRegistry) Get(code string) *Location {
+	return r.locations[NormKey(code)]
+}
+
+// Contains returns true if the given location code is known.
+func (r *LocationRegistry) Contains(code string) bool {
+	return r.Get(code) != nil
+}
+
+// Normalize returns the location code in the case of the registry, e.g.
+// `EU-28` for `eu-28`. Unknown codes are returned trimmed but unchanged.
+func (r *LocationRegistry) Normalize(code string) string {
+	if loc := r.Get(code); loc != nil {
+		return loc.Code
+	}
+	return strings.TrimSpace(code)
+}
+
+// Name returns the name of the location with the given code or an empty
+// string if the code is not known.
+func (r *LocationRegistry) Name(code string) string {
+	if loc := r.Get(code); loc != nil {
+		return loc.Name
+	}
+	return ""
+}
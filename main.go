@@ -14,8 +14,32 @@ func main() {
 		NewFlowUnmapper(args).Run()
 	case "merge":
 		NewMerger(args).Run()
+	case "split":
+		NewSplitter(args).Run()
+	case "extract":
+		NewExtractor(args).Run()
+	case "diff":
+		diffCommand(args)
 	case "model-check":
 		modelCheck(args)
+	case "validate":
+		validateCommand(args)
+	case "export-jsonld":
+		exportJSONLD(args)
+	case "import-jsonld":
+		importJSONLD(args)
+	case "export-table":
+		exportTable(args)
+	case "import-factors":
+		importFactors(args)
+	case "deregionalize":
+		deregionalize(args)
+	case "calc-model":
+		calcModels(args)
+	case "lcia":
+		lciaCommand(args)
+	case "check-regionalization":
+		checkRegionalization(args)
 	default:
 		log.Fatalln("ERROR: Unknown command", args.Command)
 	}
@@ -1,114 +1,88 @@
 package main
 
 import (
+	"encoding/csv"
 	"log"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-
-	"github.com/msrocka/ilcd"
 )
 
 // FlowMapper applies a flow mapping to the ILCD packages in a working directory.
 type FlowMapper struct {
-	workdir string
-	mapfile string
+	workdir   string
+	mapfile   string
+	hierarchy string
+	recursive bool
+	locNames  bool
 
 	flowMap *FlowMap
 }
 
 // NewFlowMapper initializes a new flow mapper from the given arguments.
 func NewFlowMapper(args *Args) *FlowMapper {
-	return &FlowMapper{workdir: args.WorkDir, mapfile: args.MapFile}
+	return &FlowMapper{
+		workdir:   args.WorkDir,
+		mapfile:   args.MapFile,
+		hierarchy: args.Hierarchy,
+		recursive: args.IsRecursive(),
+		locNames:  isTrue(args.LocNames)}
 }
 
 // Run executes the flow mapping.
 func (m *FlowMapper) Run() {
 	m.flowMap = ReadFlowMap(m.mapfile)
-	zips := GetZipNames(m.workdir)
-	for _, name := range zips {
+	if m.hierarchy != "" {
+		m.flowMap.SetHierarchy(ReadLocationHierarchy(m.hierarchy))
+	}
+	packages := GetPackageNames(m.workdir, m.recursive)
+	for _, name := range packages {
 		sourcePath := filepath.Join(m.workdir, name)
-		targetPath := filepath.Join(m.workdir, "peflocus_"+name)
+		targetPath := OutputPath(m.workdir, name, "peflocus_")
 		DeleteExisting(targetPath)
 		log.Println("INFO: map flows in", sourcePath, "to", targetPath)
 		m.doIt(sourcePath, targetPath)
 	}
 }
 
-func (m *FlowMapper) doIt(sourcePath, targetPath string) {
-
-	// create the reader and writer
-	reader, err := ilcd.NewZipReader(sourcePath)
+// writeReport writes the fallbacks of the location hierarchy that were used
+// in the mapping of a package into a CSV file.
+func (m *FlowMapper) writeReport(path string) {
+	fallbacks := m.flowMap.Fallbacks()
+	log.Println(" ... mapped", len(fallbacks), "flow/location pairs via the",
+		"location hierarchy")
+	f, err := os.Create(path)
 	if err != nil {
-		log.Println("ERROR: Failed to read zip", sourcePath, ":", err)
+		log.Println("ERROR: Failed to create file", path, err)
 		return
 	}
-	defer reader.Close()
-	writer, err := ilcd.NewZipWriter(targetPath)
-	if err != nil {
-		log.Println("ERROR: Failed to create zip writer for",
-			targetPath, ":", err)
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	writer.Write([]string{"Flow UUID", "Location", "Fallback location",
+		"New flow UUID", "Count", "Note"})
+	for _, fb := range fallbacks {
+		// see `FlowMap.unmapFlow`
+		note := "unmap with the same hierarchy keeps the location " +
+			fb.Location + "; without it, " + fb.Fallback + " is assigned"
+		writer.Write([]string{fb.FlowID, fb.Location, fb.Fallback, fb.NewID,
+			strconv.Itoa(fb.Count), note})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("ERROR: Failed to write mapping report", path, err)
 		return
 	}
-	defer writer.Close()
-
-	// map the flows in the data sets
-	flowFolder := ""
-	reader.Map(writer, func(zipFile *ilcd.ZipFile) (string, []byte) {
-		path := zipFile.Path()
-		if flowFolder == "" && ilcd.IsFlowPath(path) {
-			flowFolder = strings.Split(path, "flows")[0] + "flows/"
-		}
-		if zipFile.Type() == ilcd.FlowDataSet {
-			return "", nil // flows are filtered & written later
-		}
-		data, err := zipFile.Read()
-		if err != nil {
-			log.Println("ERROR: Failed to read entry", path, err)
-			return "", nil
-		}
-		converted, err := m.flowMap.MapFlows(path, data)
-		if err != nil {
-			log.Println("ERROR: Failed to map flows in", path, err)
-			return "", nil
-		}
-		return path, converted
-	})
+	log.Println(" ... wrote mapping report", path)
+}
 
-	gen := FlowGenerator{
+func (m *FlowMapper) doIt(sourcePath, targetPath string) {
+	ok := convertPackage(sourcePath, targetPath, &FlowGenerator{
 		flowMap:   m.flowMap,
-		folder:    flowFolder,
-		reader:    reader,
-		writer:    writer,
-		forMapped: true}
-	gen.Generate()
-
-	// copy the flows that were not mapped but are used
-	log.Println("INFO: Copy untouched but used flows")
-	count := 0
-	reader.Map(writer, func(zipFile *ilcd.ZipFile) (string, []byte) {
-		if zipFile.Type() != ilcd.FlowDataSet {
-			return "", nil
-		}
-		data, err := zipFile.Read()
-		if err != nil {
-			log.Println("ERROR: Failed to read flow", zipFile.Path())
-			return "", nil
-		}
-		flow, err := zipFile.ReadFlow()
-		if err != nil {
-			log.Println("ERROR: Failed to read flow", zipFile.Path())
-			return "", nil
-		}
-		uuid := flow.UUID()
-		if !m.flowMap.untouchedUsed[uuid] {
-			// skip all flows that where mapped or that are not used
-			return "", nil
-		}
-		path := flowFolder + uuid + "_" + flow.Version() + ".xml"
-		count++
-		return path, data
-	})
-	log.Println(" ... copied", count, "flows")
-
+		locNames:  m.locNames,
+		forMapped: true})
+	if ok && m.hierarchy != "" {
+		m.writeReport(strings.TrimSuffix(targetPath, ".zip") + "_fallbacks.csv")
+	}
 	m.flowMap.ResetStats()
 }
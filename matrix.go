@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// errSingular is returned when a linear system cannot be solved because its
+// matrix is singular.
+var errSingular = errors.New("the matrix is singular")
+
+// solve solves the linear system `a * x = b` via Gaussian elimination with
+// partial pivoting. The matrix `a` must be square; `a` and `b` are not
+// modified.
+func solve(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	m := make([][]float64, n)
+	max := 0.0
+	for i := range a {
+		m[i] = make([]float64, n+1)
+		copy(m[i], a[i])
+		m[i][n] = b[i]
+		for _, v := range a[i] {
+			max = math.Max(max, math.Abs(v))
+		}
+	}
+	eps := max * 1e-14
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) <= eps {
+			return nil, errSingular
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := col + 1; row < n; row++ {
+			f := m[row][col] / m[col][col]
+			if f == 0 {
+				continue
+			}
+			for k := col; k <= n; k++ {
+				m[row][k] -= f * m[col][k]
+			}
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := m[row][n]
+		for k := row + 1; k < n; k++ {
+			sum -= m[row][k] * x[k]
+		}
+		x[row] = sum / m[row][row]
+	}
+	return x, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolve(t *testing.T) {
+	tests := []struct {
+		name string
+		a    [][]float64
+		b    []float64
+		want []float64
+	}{
+		{
+			// the reference process 1 needs 2 units of the output of process
+			// 2 which needs 0.5 units of the output of process 3
+			name: "chain",
+			a: [][]float64{
+				{1, 0, 0},
+				{-2, 1, 0},
+				{0, -0.5, 1}},
+			b:    []float64{1, 0, 0},
+			want: []float64{1, 2, 1},
+		},
+		{
+			// process 2 provides 2 units per scaling and needs 0.5 units of
+			// the output of process 3 which again needs 0.5 units of the
+			// output of process 2
+			name: "loop",
+			a: [][]float64{
+				{1, 0, 0},
+				{-4, 2, -0.5},
+				{0, -0.5, 1}},
+			b:    []float64{1, 0, 0},
+			want: []float64{1, 16.0 / 7.0, 8.0 / 7.0},
+		},
+		{
+			name: "pivoting",
+			a: [][]float64{
+				{0, 1},
+				{1, 0}},
+			b:    []float64{3, 4},
+			want: []float64{4, 3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			x, err := solve(test.a, test.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(x) != len(test.want) {
+				t.Fatalf("got %d values, want %d", len(x), len(test.want))
+			}
+			for i := range x {
+				if math.Abs(x[i]-test.want[i]) > 1e-12 {
+					t.Errorf("x[%d] = %v, want %v", i, x[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSolveSingular(t *testing.T) {
+	// process 2 provides exactly what process 3 needs and process 3 provides
+	// exactly what process 2 needs: the loop is not connected to the demand
+	a := [][]float64{
+		{1, 0, 0},
+		{0, 1, -1},
+		{0, -1, 1}}
+	if _, err := solve(a, []float64{1, 0, 0}); err != errSingular {
+		t.Errorf("got error %v, want %v", err, errSingular)
+	}
+}
+
+func TestSolveKeepsInput(t *testing.T) {
+	a := [][]float64{{2, 1}, {1, 3}}
+	b := []float64{3, 5}
+	if _, err := solve(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if a[0][0] != 2 || a[0][1] != 1 || a[1][0] != 1 || a[1][1] != 3 ||
+		b[0] != 3 || b[1] != 5 {
+		t.Error("the input of solve was modified")
+	}
+}
@@ -1,108 +1,134 @@
 package main
 
 import (
+	"archive/zip"
 	"encoding/xml"
+	"errors"
+	"io"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/beevik/etree"
 	"github.com/msrocka/ilcd"
 )
 
-// Merger merges a set of ILCD zip packages into a single file.
+// Merger merges a set of ILCD zip packages into a single file. The entries
+// are copied as streams so that also large packages can be merged with
+// bounded memory.
 type Merger struct {
-	workdir  string
-	skipDocs bool
-	content  map[string]bool
+	workdir   string
+	skipDocs  bool
+	recursive bool
+	content   map[string]bool
 }
 
 // NewMerger initializes a new merger from the given args.
 func NewMerger(args *Args) *Merger {
-	m := &Merger{workdir: args.WorkDir, content: make(map[string]bool)}
-	if args.SkipDocs == "true" || args.SkipDocs == "1" {
-		m.skipDocs = true
-	}
-	return m
+	return &Merger{
+		workdir:   args.WorkDir,
+		skipDocs:  isTrue(args.SkipDocs),
+		recursive: args.IsRecursive(),
+		content:   make(map[string]bool)}
 }
 
 // Run executes the package merging
 func (m *Merger) Run() {
 	destPath := filepath.Join(m.workdir, "peflocus_merged.zip")
-	DeleteExisting(destPath)
-	writer, err := ilcd.NewZipWriter(destPath)
-	if err != nil {
-		log.Fatalln("ERROR: cannot write to zip file", destPath, ": ", err)
-	}
-	defer writer.Close()
-	zips := GetZipNames(m.workdir)
-	log.Println("Merge", len(zips), "zip files into", destPath)
-	for _, name := range zips {
-		reader, err := ilcd.NewZipReader(filepath.Join(m.workdir, name))
+	writer, closeFn := createZip(destPath)
+	defer closeFn()
+	packages := GetPackageNames(m.workdir, m.recursive)
+	log.Println("Merge", len(packages), "packages into", destPath)
+	for _, name := range packages {
+		reader, err := OpenPackage(filepath.Join(m.workdir, name))
 		if err != nil {
-			log.Println("ERROR: failed to read zip", name, ": ", err)
+			log.Println("ERROR: failed to read package", name, ": ", err)
 			continue
 		}
-		log.Println("INFO: add zip", name)
+		log.Println("INFO: add package", name)
 		m.doIt(reader, writer)
 		if err = reader.Close(); err != nil {
-			log.Println("ERROR: failed to close zip", name, ": ", err)
+			log.Println("ERROR: failed to close package", name, ": ", err)
 		}
 	}
 	log.Println("INFO: merged", len(m.content), "entries into a single file")
 }
 
-func (m *Merger) doIt(reader *ilcd.ZipReader, writer *ilcd.ZipWriter) {
-	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
-		t := zipFile.Type()
-		if t == ilcd.Asset {
-			log.Println("INFO: ignore", zipFile.Path())
-			return true
-		}
-		if t == ilcd.ExternalDoc {
-			if m.skipDocs {
-				return true
-			}
-			m.addExternalDoc(writer, zipFile)
-			return true
+func (m *Merger) doIt(reader *PackageReader, writer *zip.Writer) {
+	source, err := zip.OpenReader(reader.ZipPath())
+	if err != nil {
+		log.Println("ERROR: failed to open zip file", reader.ZipPath(), err)
+		return
+	}
+	defer source.Close()
+	for _, f := range source.File {
+		if f.FileInfo().IsDir() {
+			continue
 		}
+		m.add(writer, f)
+	}
+}
 
-		data, err := zipFile.Read()
-		if err != nil {
-			log.Println("ERROR: could not read zip entry", zipFile.Path(), err)
-			return true
-		}
-		ds := m.init(t)
-		if err := xml.Unmarshal(data, ds); err != nil {
-			log.Println("ERROR: could not load data set", zipFile.Path(), err)
-			return true
-		}
-		path := "ILCD/" + t.Folder() + "/" + ds.UUID() + ".xml"
-		if !m.content[path] {
-			m.content[path] = true
-			err := writer.Write(path, data)
-			if err != nil {
-				log.Println("ERROR: failed to add data set", path, err)
-			} else {
-				log.Println("INFO: added data set", path)
-			}
+// createZip creates a new zip file at the given path. An existing file is
+// deleted. The returned function closes the writer and the file.
+func createZip(path string) (*zip.Writer, func()) {
+	DeleteExisting(path)
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalln("ERROR: cannot write to zip file", path, ": ", err)
+	}
+	writer := zip.NewWriter(file)
+	return writer, func() {
+		if err := writer.Close(); err != nil {
+			log.Println("ERROR: failed to close zip file", path, ": ", err)
 		}
-		return true
-	})
+		file.Close()
+	}
 }
 
-func (m *Merger) addExternalDoc(writer *ilcd.ZipWriter, zipFile *ilcd.ZipFile) {
-	doc := m.docName(zipFile.Path())
-	path := "ILCD/" + ilcd.ExternalDoc.Folder() + "/" + doc
-	if doc == "" || m.content[path] {
+// add writes the given zip entry into the merged package if a data set with
+// the same type and UUID or an external document with the same name was not
+// added yet.
+func (m *Merger) add(writer *zip.Writer, f *zip.File) {
+	t := GetPathType(f.Name)
+	if t < 0 || t == ilcd.Asset {
+		log.Println("INFO: ignore", f.Name)
 		return
 	}
-	data, err := zipFile.Read()
-	if err != nil {
-		log.Println("ERROR: failed to read", zipFile.Path(), err)
+	if t == ilcd.ExternalDoc {
+		if m.skipDocs {
+			return
+		}
+		m.addExternalDoc(writer, f)
 		return
 	}
-	err = writer.Write(path, data)
+
+	uuid, err := readEntryUUID(f)
 	if err != nil {
+		log.Println("ERROR: could not load data set", f.Name, err)
+		return
+	}
+	path := "ILCD/" + t.Folder() + "/" + uuid + ".xml"
+	if m.content[path] {
+		return
+	}
+	m.content[path] = true
+	if err := copyEntry(writer, f, path); err != nil {
+		log.Println("ERROR: failed to add data set", path, err)
+	} else {
+		log.Println("INFO: added data set", path)
+	}
+}
+
+func (m *Merger) addExternalDoc(writer *zip.Writer, f *zip.File) {
+	doc := ExternalDocName(f.Name)
+	path := "ILCD/" + ilcd.ExternalDoc.Folder() + "/" + doc
+	if doc == "" || m.content[path] {
+		return
+	}
+	if err := copyEntry(writer, f, path); err != nil {
 		log.Println("ERROR: failed to add external doc", doc, err)
 	} else {
 		log.Println("INFO: added external doc", doc)
@@ -110,33 +136,86 @@ func (m *Merger) addExternalDoc(writer *ilcd.ZipWriter, zipFile *ilcd.ZipFile) {
 	}
 }
 
-func (m *Merger) docName(path string) string {
-	parts := strings.Split(path, ilcd.ExternalDoc.Folder())
-	if len(parts) < 2 {
-		return ""
+// copyEntry copies the given zip entry into a new entry with the given path.
+// The compressed data are copied as they are, without decompressing and
+// compressing them again.
+func copyEntry(writer *zip.Writer, f *zip.File, path string) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	header := f.FileHeader
+	header.Name = path
+	w, err := writer.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// createEntry creates a new compressed entry with the given path and
+// modification time in the given zip writer.
+func createEntry(writer *zip.Writer, path string, modified time.Time) (io.Writer, error) {
+	return writer.CreateHeader(&zip.FileHeader{
+		Name:     path,
+		Method:   zip.Deflate,
+		Modified: modified})
+}
+
+// writeEntry writes the given data into a new entry with the given path.
+func writeEntry(writer *zip.Writer, path string, data []byte) error {
+	w, err := createEntry(writer, path, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readEntryDoc reads the XML document of the given zip entry.
+func readEntryDoc(f *zip.File, doc *etree.Document) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
 	}
-	return strings.TrimLeft(parts[1], "/\\")
+	defer r.Close()
+	_, err = doc.ReadFrom(r)
+	return err
 }
 
-func (m *Merger) init(t ilcd.DataSetType) ilcd.DataSet {
-	switch t {
-	case ilcd.ContactDataSet:
-		return &ilcd.Contact{}
-	case ilcd.SourceDataSet:
-		return &ilcd.Source{}
-	case ilcd.UnitGroupDataSet:
-		return &ilcd.UnitGroup{}
-	case ilcd.FlowPropertyDataSet:
-		return &ilcd.FlowProperty{}
-	case ilcd.FlowDataSet:
-		return &ilcd.Flow{}
-	case ilcd.ProcessDataSet:
-		return &ilcd.Process{}
-	case ilcd.MethodDataSet:
-		return &ilcd.Method{}
-	case ilcd.ModelDataSet:
-		return &ilcd.Model{}
-	default:
-		return nil
+// readEntryUUID reads the UUID of the data set in the given zip entry. Only
+// the beginning of the data set is parsed up to the UUID.
+func readEntryUUID(f *zip.File) (string, error) {
+	r, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	dec := xml.NewDecoder(r)
+	var stack []string
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return "", errors.New("no UUID found")
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			n := len(stack)
+			if n > 1 && stack[n-1] == "UUID" && stack[n-2] == "dataSetInformation" {
+				if uuid := strings.TrimSpace(string(t)); uuid != "" {
+					return uuid, nil
+				}
+			}
+		}
 	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// checkAmounts checks the amounts of the given model: it solves the linear
+// system of the model for its reference flow and checks that the processes
+// can be scaled consistently. It also checks that linked outputs and inputs
+// have the same reference flow property.
+func checkAmounts(info *modelInfo, finder DataSetFinder, subject *Subject) {
+	checkFlowProperties(info, finder, subject)
+	sys := newModelSystem(info)
+	if sys == nil {
+		subject.Warning("could not check the amounts of the model as the",
+			"reference process is missing")
+		return
+	}
+
+	for _, id := range sys.ids {
+		if id == sys.refID || sys.primary[id] != "" {
+			continue
+		}
+		pid := info.processes[id].UUID()
+		f := subject.Warning("process internalID=", id, "UUID=", pid,
+			"does not provide a linked output; it is not scaled")
+		f.InternalID = strconv.Itoa(id)
+		f.Process = pid
+	}
+	for _, link := range sys.links {
+		if link.provider == sys.refID || link.outputFlow != sys.primary[link.provider] {
+			continue
+		}
+		if sys.amount(link.provider, link.outputFlow, "Output") == 0 {
+			pid := info.processes[link.provider].UUID()
+			f := subject.Error("process ID=", pid,
+				"has a zero amount of linked output", link.outputFlow)
+			f.InternalID = strconv.Itoa(link.provider)
+			f.Process = pid
+			f.Flow = link.outputFlow
+		}
+	}
+
+	solution, err := sys.solve()
+	if err != nil {
+		subject.Error("the linear system of the model is singular; the",
+			"processes cannot be scaled")
+		for _, cycle := range sys.cycles() {
+			if f := info.cycles[cycleKey(cycle)]; f != nil {
+				f.Severity = SeverityError
+				continue
+			}
+			f := subject.Error("the model contains a cycle:", cycleString(cycle))
+			f.InternalID = strconv.Itoa(cycle[0])
+		}
+		return
+	}
+
+	for _, id := range sys.ids {
+		if s := sys.scaling(solution, id); s < 0 {
+			pid := info.processes[id].UUID()
+			f := subject.Error("process internalID=", id, "UUID=", pid,
+				"has a negative scaling factor:", s)
+			f.InternalID = strconv.Itoa(id)
+			f.Process = pid
+		}
+	}
+	checkSupply(sys, solution, subject)
+}
+
+// checkSupply checks for each linked output of a provider that the scaled
+// amount is enough to supply the scaled demands of the linked recipients.
+func checkSupply(sys *modelSystem, solution []float64, subject *Subject) {
+	type output struct {
+		provider int
+		flow     string
+	}
+	demands := make(map[output]float64)
+	var outputs []output
+	for _, link := range sys.links {
+		key := output{link.provider, link.outputFlow}
+		if _, ok := demands[key]; !ok {
+			outputs = append(outputs, key)
+		}
+		demands[key] += sys.scaling(solution, link.recipient) * sys.linkDemand(link)
+	}
+	for _, key := range outputs {
+		demand := demands[key]
+		supply := sys.scaling(solution, key.provider) *
+			sys.amount(key.provider, key.flow, "Output")
+		if supply >= demand || math.Abs(supply-demand) <= 1e-9*math.Abs(demand) {
+			continue
+		}
+		pid := sys.info.processes[key.provider].UUID()
+		f := subject.Error("the demanded input of flow", key.flow, "(",
+			demand, ") cannot be supplied by process ID=", pid, "(", supply, ")")
+		f.InternalID = strconv.Itoa(key.provider)
+		f.Process = pid
+		f.Flow = key.flow
+	}
+}
+
+// checkFlowProperties checks that the flows of linked outputs and inputs have
+// the same reference flow property.
+func checkFlowProperties(info *modelInfo, finder DataSetFinder, subject *Subject) {
+	flows := NewFlowIndex(finder)
+	property := func(flowID string) string {
+		if flow := flows.Get(flowID); flow != nil {
+			return flow.Property
+		}
+		return ""
+	}
+
+	for _, pi := range info.model.Processes {
+		for _, con := range pi.Connections {
+			for _, link := range con.Links {
+				if link.InputFlow == "" || link.InputFlow == con.OutputFlow {
+					continue
+				}
+				outProp := property(con.OutputFlow)
+				inProp := property(link.InputFlow)
+				if outProp == "" || inProp == "" {
+					f := subject.Warning("could not check the flow properties of",
+						"the link", pi.InternalID, "->", link.ProcessID,
+						"as the flow", con.OutputFlow, "or", link.InputFlow,
+						"is missing")
+					f.InternalID = strconv.Itoa(pi.InternalID)
+					f.Flow = con.OutputFlow
+					continue
+				}
+				if outProp != inProp {
+					f := subject.Error("the output flow", con.OutputFlow,
+						"of process internalID=", pi.InternalID,
+						"and the input flow", link.InputFlow,
+						"of process internalID=", link.ProcessID,
+						"have different reference flow properties")
+					f.InternalID = strconv.Itoa(pi.InternalID)
+					f.Flow = con.OutputFlow
+				}
+			}
+		}
+	}
+}
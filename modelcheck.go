@@ -1,57 +1,168 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/beevik/etree"
 	"github.com/msrocka/ilcd"
 )
 
 func modelCheck(args *Args) {
-	for _, zipName := range GetZipNames(args.WorkDir) {
-		zipPath := filepath.Join(args.WorkDir, zipName)
-		reader, err := ilcd.NewZipReader(zipPath)
+	format := strings.ToLower(args.Format)
+	if !IsValidFormat(format) {
+		log.Fatalln("ERROR: Unknown format", args.Format)
+	}
+	textMode := format == "" || format == "text"
+	if args.Graphs != "" {
+		if err := os.MkdirAll(args.Graphs, os.ModePerm); err != nil {
+			log.Fatalln("ERROR: Failed to create graph folder", args.Graphs, err)
+		}
+	}
+	var flowMap *FlowMap
+	if args.MapFileGiven {
+		flowMap = ReadFlowMap(args.MapFile)
+	}
+	var lib *Library
+	if args.Lib != "" {
+		lib = OpenLibrary(args.Lib)
+		defer lib.Close()
+	}
+	report := &Report{}
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
 		if err != nil {
-			log.Println("ERROR: Could not read ILCD package", zipPath)
+			log.Println("ERROR: Could not read ILCD package", path)
 			continue
 		}
-		fmt.Println("\nCheck models in", zipPath)
+		if textMode {
+			fmt.Println("\nCheck models in", path)
+		}
 		reader.EachModel(func(model *ilcd.Model) bool {
-			printModelReport(model, reader)
-			printGraph(model)
+			subject := report.NewSubject(name, ilcd.ModelDataSet.Folder(),
+				model.UUID(), model.FullName("en"))
+			finder := lib.With(reader.ZipReader)
+			info := checkModel(model, finder, flowMap, subject)
+			checkGraph(info, subject)
+			checkAmounts(info, finder, subject)
+			if args.Graphs != "" {
+				writeGraph(info, args.Graphs)
+			}
+			if textMode {
+				fmt.Println("\nCheck model", subject.Name)
+				WriteText(os.Stdout, subject)
+				if isTrue(args.Dot) {
+					printGraph(model)
+				}
+			}
 			return true
 		})
+		reader.Close()
 	}
+	if textMode {
+		return
+	}
+	if err := report.Write(os.Stdout, format); err != nil {
+		log.Fatalln("ERROR: Failed to write report", err)
+	}
+}
+
+// modelInfo contains the data of a life cycle model that are collected when
+// the model is checked.
+type modelInfo struct {
+	model *ilcd.Model
+
+	// internal ID -> process; only contains the processes that could be read
+	processes map[int]*ilcd.Process
+
+	// internal ID -> process name
+	names map[int]string
+
+	// flow UUID -> flow name, as given in the exchanges of the processes
+	flowNames map[string]string
+
+	// internal ID -> the exchanges of the process
+	exchanges map[int][]*FlowAmount
+
+	// internal ID -> the internal ID of the reference flow of the process
+	refFlows map[int]string
 
+	// the links that are broken; see `linkKey`
+	brokenLinks map[string]bool
+
+	// the cycles of the model graph that were reported as warnings by
+	// `checkGraph`; see `cycleKey`
+	cycles map[string]*Finding
+
+	// the flow mapping that is used to detect links that are broken by
+	// mapping flows; nil if no mapping file is available
+	flowMap *FlowMap
 }
 
-func printModelReport(model *ilcd.Model, reader *ilcd.ZipReader) {
-	fmt.Println("\nCheck model", model.FullName("en"))
+// linkKey returns the key of a link between the given process instances via
+// the given flow.
+func linkKey(provider, recipient int, flow string) string {
+	return fmt.Sprintf("%d->%d/%s", provider, recipient, flow)
+}
+
+// checkModel checks the processes and connections of the given model. The
+// processes are searched with the given finder, e.g. in the package of the
+// model and the packages of a library. If a flow mapping is given, it is used
+// to detect links that are broken because the flows of the model or of its
+// processes were mapped.
+func checkModel(model *ilcd.Model, finder DataSetFinder, flowMap *FlowMap,
+	subject *Subject) *modelInfo {
+	info := &modelInfo{
+		flowMap:     flowMap,
+		model:       model,
+		processes:   make(map[int]*ilcd.Process),
+		names:       make(map[int]string),
+		flowNames:   make(map[string]string),
+		exchanges:   make(map[int][]*FlowAmount),
+		refFlows:    make(map[int]string),
+		brokenLinks: make(map[string]bool),
+		cycles:      make(map[string]*Finding)}
+	processes := info.processes
 
 	if model.RefProcess() == nil {
-		fmt.Println("  .. error: the reference process does not exist")
+		subject.Error("the reference process does not exist")
 	}
 
 	// read and check the processes
-	processes := make(map[int]*ilcd.Process)
 	for _, pi := range model.Processes {
+		internalID := strconv.Itoa(pi.InternalID)
 		if pi.Process == nil {
-			fmt.Println("  .. error: no process ref. in", pi.InternalID)
+			subject.Error("no process ref. in", pi.InternalID).
+				InternalID = internalID
 			continue
 		}
-		zfile := reader.FindDataSet(ilcd.ProcessDataSet, pi.Process.UUID)
+		zfile := finder.FindDataSet(ilcd.ProcessDataSet, pi.Process.UUID)
 		if zfile == nil {
-			fmt.Println("  .. error: process with ID=",
-				pi.Process.UUID, "does not exits")
+			f := subject.Error("process with ID=", pi.Process.UUID,
+				"does not exist")
+			f.InternalID = internalID
+			f.Process = pi.Process.UUID
 			continue
 		}
-		process, err := zfile.ReadProcess()
+		data, err := zfile.Read()
+		process := &ilcd.Process{}
+		if err == nil {
+			err = xml.Unmarshal(data, process)
+		}
 		if err != nil {
-			fmt.Println("  .. error: failed to read process ID=", pi.Process.UUID)
+			f := subject.Error("failed to read process ID=", pi.Process.UUID)
+			f.InternalID = internalID
+			f.Process = pi.Process.UUID
 			continue
 		}
 		processes[pi.InternalID] = process
+		info.readProcess(pi.InternalID, data)
 	}
 
 	// check the connections
@@ -60,30 +171,92 @@ func printModelReport(model *ilcd.Model, reader *ilcd.ZipReader) {
 		if provider == nil {
 			continue
 		}
-		fmt.Println("  .. info: check process internalID=",
-			pi.InternalID, "UUID=", provider.UUID())
+		internalID := strconv.Itoa(pi.InternalID)
 		for _, con := range pi.Connections {
 			output := findExchange(provider, con.OutputFlow, "Output")
 			if output == nil {
-				fmt.Println("  .. error: process ID=", pi.Process.UUID,
+				f := subject.Error("process ID=", pi.Process.UUID,
 					"has no output with flow", con.OutputFlow)
+				f.InternalID = internalID
+				f.Process = pi.Process.UUID
+				f.Flow = con.OutputFlow
+				info.checkMapping(pi.InternalID, con.OutputFlow, "Output", subject)
+				for _, link := range con.Links {
+					info.brokenLinks[linkKey(pi.InternalID, link.ProcessID,
+						con.OutputFlow)] = true
+				}
 				continue
 			}
 			for _, link := range con.Links {
 				recipient := processes[link.ProcessID]
 				if recipient == nil {
-					fmt.Println("  .. error: process with internalID=",
-						link.ProcessID, "does not exist")
+					info.brokenLinks[linkKey(pi.InternalID, link.ProcessID,
+						con.OutputFlow)] = true
+					subject.Error("process with internalID=",
+						link.ProcessID, "does not exist").
+						InternalID = strconv.Itoa(link.ProcessID)
 					continue
 				}
 				input := findExchange(recipient, link.InputFlow, "Input")
 				if input == nil {
-					fmt.Println("  .. error: process ID=", recipient.UUID(),
-						" has no input of flow", link.InputFlow)
+					info.brokenLinks[linkKey(pi.InternalID, link.ProcessID,
+						con.OutputFlow)] = true
+					f := subject.Error("process ID=", recipient.UUID(),
+						"has no input of flow", link.InputFlow)
+					f.InternalID = strconv.Itoa(link.ProcessID)
+					f.Process = recipient.UUID()
+					f.Flow = link.InputFlow
+					info.checkMapping(link.ProcessID, link.InputFlow, "Input", subject)
 				}
 			}
 		}
 	}
+	return info
+}
+
+// readProcess reads the name, the exchanges, and the reference flow of the
+// process with the given internal ID from the given process data set.
+func (info *modelInfo) readProcess(internalID int, data []byte) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return
+	}
+	info.names[internalID] = DataSetName(doc)
+	info.refFlows[internalID] = ReferenceFlowID(doc)
+	info.exchanges[internalID] = ReadExchanges(doc)
+	for _, e := range info.exchanges[internalID] {
+		if e.FlowName != "" && info.flowNames[e.FlowID] == "" {
+			info.flowNames[e.FlowID] = e.FlowName
+		}
+	}
+}
+
+// checkMapping adds an error if the flow of a broken link is related via the
+// flow mapping to a flow of an exchange with the given direction in the
+// process with the given internal ID. In this case, the flows of the model
+// and the process were not mapped consistently.
+func (info *modelInfo) checkMapping(internalID int, flowID, direction string,
+	subject *Subject) {
+	if info.flowMap == nil {
+		return
+	}
+	for _, e := range info.exchanges[internalID] {
+		if !strings.EqualFold(e.Direction, direction) ||
+			!info.flowMap.IsMappedPair(flowID, e.FlowID, e.Location) {
+			continue
+		}
+		pid := info.processes[internalID].UUID()
+		msg := []interface{}{"the link via flow", flowID, "is broken by the flow",
+			"mapping: process ID=", pid, "contains the mapped flow", e.FlowID}
+		if e.Location != "" {
+			msg = append(msg, "at location", e.Location)
+		}
+		f := subject.Error(msg...)
+		f.InternalID = strconv.Itoa(internalID)
+		f.Process = pid
+		f.Flow = flowID
+		f.Location = e.Location
+	}
 }
 
 func findExchange(process *ilcd.Process,
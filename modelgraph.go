@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeGraph writes the graph of the given model as DOT file
+// `<model UUID>.dot` into the given folder. The nodes are labeled with the
+// process names and the edges with the names of the linked flows. The
+// reference process is filled pink; missing processes and broken links are
+// drawn red and dashed.
+func writeGraph(info *modelInfo, folder string) {
+	model := info.model
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "digraph", dotQuote(model.FullName("en")), "{")
+	fmt.Fprintln(&buf, "  node [shape=box style=rounded];")
+
+	refID := -1
+	if ref := model.RefProcess(); ref != nil {
+		refID = ref.InternalID
+	}
+
+	// the process nodes
+	instances := make(map[int]bool)
+	for _, pi := range model.Processes {
+		instances[pi.InternalID] = true
+		label := info.names[pi.InternalID]
+		if label == "" && pi.Process != nil {
+			label = pi.Process.UUID
+		}
+		label += "\n(" + strconv.Itoa(pi.InternalID) + ")"
+		var attrs []string
+		switch {
+		case info.processes[pi.InternalID] == nil:
+			label = "missing process\n" + label
+			attrs = append(attrs, "color=red", "fontcolor=red",
+				`style="rounded,dashed"`)
+		case pi.InternalID == refID:
+			attrs = append(attrs, "fillcolor=pink", `style="rounded,filled"`)
+		}
+		attrs = append([]string{"label=" + dotQuote(label)}, attrs...)
+		fmt.Fprintf(&buf, "  %d [%s];\n", pi.InternalID, strings.Join(attrs, " "))
+	}
+
+	// links to process instances that are not defined in the model
+	for _, pi := range model.Processes {
+		for _, con := range pi.Connections {
+			for _, link := range con.Links {
+				if instances[link.ProcessID] {
+					continue
+				}
+				instances[link.ProcessID] = true
+				label := "unknown process instance\n(" +
+					strconv.Itoa(link.ProcessID) + ")"
+				fmt.Fprintf(&buf, "  %d [label=%s color=red fontcolor=red "+
+					"style=\"rounded,dashed\"];\n", link.ProcessID, dotQuote(label))
+			}
+		}
+	}
+
+	// the links
+	for _, pi := range model.Processes {
+		for _, con := range pi.Connections {
+			label := info.flowNames[con.OutputFlow]
+			if label == "" {
+				label = con.OutputFlow
+			}
+			for _, link := range con.Links {
+				attrs := "label=" + dotQuote(label)
+				if info.brokenLinks[linkKey(pi.InternalID, link.ProcessID, con.OutputFlow)] {
+					attrs += " color=red fontcolor=red style=dashed"
+				}
+				fmt.Fprintf(&buf, "  %d -> %d [%s];\n", pi.InternalID,
+					link.ProcessID, attrs)
+			}
+		}
+	}
+	fmt.Fprintln(&buf, "}")
+
+	path := filepath.Join(folder, model.UUID()+".dot")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		log.Println("ERROR: Failed to write model graph", path, err)
+		return
+	}
+	log.Println("INFO: wrote model graph", path)
+}
+
+// dotQuote returns the given text as quoted DOT string.
+func dotQuote(text string) string {
+	text = strings.Replace(text, "\\", "\\\\", -1)
+	text = strings.Replace(text, "\"", "\\\"", -1)
+	text = strings.Replace(text, "\n", "\\n", -1)
+	return "\"" + text + "\""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkGraph analyzes the graph of the given model: it reports duplicate
+// internal IDs, links of processes to themselves, duplicate links, inputs that
+// are linked to multiple providers, cycles, and process instances that are not
+// connected to the reference process.
+func checkGraph(info *modelInfo, subject *Subject) {
+	model := info.model
+
+	// duplicate internal IDs
+	counts := make(map[int]int)
+	var ids []int
+	for _, pi := range model.Processes {
+		if counts[pi.InternalID] == 0 {
+			ids = append(ids, pi.InternalID)
+		}
+		counts[pi.InternalID]++
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if counts[id] > 1 {
+			subject.Error("the internal ID", id, "is used by", counts[id],
+				"process instances").InternalID = strconv.Itoa(id)
+		}
+	}
+
+	// collect the edges; self links are reported and not added to the graph
+	graph := make(map[int][]int)
+	reverse := make(map[int][]int)
+	linked := make(map[int]bool)
+	providers := make(map[string][]int)
+	var inputs []string
+	linkCounts := make(map[string]int)
+	for _, pi := range model.Processes {
+		for _, con := range pi.Connections {
+			for _, link := range con.Links {
+				if link.ProcessID == pi.InternalID {
+					f := subject.Error("process internalID=", pi.InternalID,
+						"is linked to itself via flow", con.OutputFlow)
+					f.InternalID = strconv.Itoa(pi.InternalID)
+					f.Flow = con.OutputFlow
+					continue
+				}
+
+				// a duplicate link is reported once and not added to the graph again
+				key := linkKey(pi.InternalID, link.ProcessID, con.OutputFlow)
+				linkCounts[key]++
+				if linkCounts[key] == 2 {
+					f := subject.Error("process internalID=", pi.InternalID,
+						"is linked more than once to process internalID=",
+						link.ProcessID, "via flow", con.OutputFlow)
+					f.InternalID = strconv.Itoa(pi.InternalID)
+					f.Flow = con.OutputFlow
+				}
+				if linkCounts[key] > 1 {
+					continue
+				}
+
+				graph[pi.InternalID] = append(graph[pi.InternalID], link.ProcessID)
+				reverse[link.ProcessID] = append(reverse[link.ProcessID], pi.InternalID)
+				linked[pi.InternalID] = true
+				linked[link.ProcessID] = true
+
+				inputFlow := link.InputFlow
+				if inputFlow == "" {
+					inputFlow = con.OutputFlow
+				}
+				input := inputKey(link.ProcessID, inputFlow)
+				if !containsInt(providers[input], pi.InternalID) {
+					if providers[input] == nil {
+						inputs = append(inputs, input)
+					}
+					providers[input] = append(providers[input], pi.InternalID)
+				}
+			}
+		}
+	}
+
+	// inputs with multiple providers
+	for _, key := range inputs {
+		list := providers[key]
+		if len(list) < 2 {
+			continue
+		}
+		recipient, flow := splitInputKey(key)
+		f := subject.Warning("the input of flow", flow, "of process internalID=",
+			recipient, "is linked to multiple providers:", list)
+		f.InternalID = strconv.Itoa(recipient)
+		f.Flow = flow
+	}
+
+	// cycles; these are raised to errors by `checkAmounts` when the system
+	// of the model is singular
+	for _, cycle := range findCycles(ids, graph) {
+		f := subject.Warning("the model contains a cycle:", cycleString(cycle))
+		f.InternalID = strconv.Itoa(cycle[0])
+		info.cycles[cycleKey(cycle)] = f
+	}
+
+	// process instances that are not connected to the reference process;
+	// a process is connected when the reference process can be reached from
+	// it by following the links downstream
+	ref := model.RefProcess()
+	if ref == nil {
+		return
+	}
+	connected := map[int]bool{ref.InternalID: true}
+	queue := []int{ref.InternalID}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for _, provider := range reverse[next] {
+			if !connected[provider] {
+				connected[provider] = true
+				queue = append(queue, provider)
+			}
+		}
+	}
+	for _, id := range ids {
+		if connected[id] {
+			continue
+		}
+		var f *Finding
+		if linked[id] {
+			f = subject.Warning("process internalID=", id,
+				"is not connected to the reference process")
+		} else {
+			f = subject.Warning("process internalID=", id,
+				"is not linked to any other process")
+		}
+		f.InternalID = strconv.Itoa(id)
+		if p := info.processes[id]; p != nil {
+			f.Process = p.UUID()
+		}
+	}
+}
+
+// splitInputKey splits a key that was created with `inputKey`.
+func splitInputKey(key string) (int, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) < 2 {
+		return 0, key
+	}
+	id, _ := strconv.Atoi(parts[0])
+	return id, parts[1]
+}
+
+func containsInt(list []int, val int) bool {
+	for _, i := range list {
+		if i == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// modelLink is a link between an output of a provider and an input of a
+// recipient process in a life cycle model.
+type modelLink struct {
+	provider   int
+	recipient  int
+	outputFlow string
+	inputFlow  string
+}
+
+// modelSystem is the linear system of a life cycle model. Each process
+// instance of the model is a column of the technology matrix. The row of the
+// reference process fixes its scaling factor to 1. The row of another process
+// describes the balance of its (first) linked output: the amount that the
+// process provides must be equal to the amounts that are demanded by the
+// linked recipients.
+type modelSystem struct {
+	info *modelInfo
+
+	// column index -> internal ID
+	ids []int
+
+	// internal ID -> column index
+	index map[int]int
+
+	// the internal ID of the reference process
+	refID int
+
+	// the valid links of the model
+	links []*modelLink
+
+	// <recipient>/<input flow> -> number of providers; if an input is linked
+	// to multiple providers, the demand is split equally between them
+	providers map[string]int
+
+	// internal ID -> the output flow that defines the row of the process; not
+	// set for the reference process and for processes without linked outputs
+	primary map[int]string
+
+	tech   [][]float64
+	demand []float64
+}
+
+// newModelSystem builds the linear system of the model from the data that
+// were collected when checking the model. It returns nil if the reference
+// process of the model could not be read.
+func newModelSystem(info *modelInfo) *modelSystem {
+	ref := info.model.RefProcess()
+	if ref == nil || info.processes[ref.InternalID] == nil {
+		return nil
+	}
+	sys := &modelSystem{
+		info:      info,
+		index:     make(map[int]int),
+		refID:     ref.InternalID,
+		providers: make(map[string]int),
+		primary:   make(map[int]string)}
+	for id := range info.processes {
+		sys.ids = append(sys.ids, id)
+	}
+	sort.Ints(sys.ids)
+	for i, id := range sys.ids {
+		sys.index[id] = i
+	}
+
+	// collect the valid links
+	for _, pi := range info.model.Processes {
+		if info.processes[pi.InternalID] == nil {
+			continue
+		}
+		for _, con := range pi.Connections {
+			for _, link := range con.Links {
+				if info.processes[link.ProcessID] == nil ||
+					info.brokenLinks[linkKey(pi.InternalID, link.ProcessID, con.OutputFlow)] {
+					continue
+				}
+				sys.links = append(sys.links, &modelLink{
+					provider:   pi.InternalID,
+					recipient:  link.ProcessID,
+					outputFlow: con.OutputFlow,
+					inputFlow:  link.InputFlow})
+				sys.providers[inputKey(link.ProcessID, link.InputFlow)]++
+				if pi.InternalID != sys.refID && sys.primary[pi.InternalID] == "" {
+					sys.primary[pi.InternalID] = con.OutputFlow
+				}
+			}
+		}
+	}
+
+	// build the matrix
+	n := len(sys.ids)
+	sys.tech = make([][]float64, n)
+	sys.demand = make([]float64, n)
+	for i, id := range sys.ids {
+		sys.tech[i] = make([]float64, n)
+		if id == sys.refID {
+			refAmount := sys.refAmount()
+			sys.tech[i][i] = refAmount
+			sys.demand[i] = refAmount
+			continue
+		}
+		flow := sys.primary[id]
+		if flow == "" {
+			// the process is not linked to any other process; thus it is
+			// not scaled
+			sys.tech[i][i] = 1
+			continue
+		}
+		sys.tech[i][i] += sys.amount(id, flow, "Output")
+		for _, link := range sys.links {
+			if link.provider == id && link.outputFlow == flow {
+				sys.tech[i][sys.index[link.recipient]] -= sys.linkDemand(link)
+			}
+		}
+	}
+	return sys
+}
+
+func inputKey(recipient int, flow string) string {
+	return strconv.Itoa(recipient) + "/" + flow
+}
+
+// solve calculates the scaling factors of the processes; the result is
+// indexed by the columns of the system.
+func (sys *modelSystem) solve() ([]float64, error) {
+	return solve(sys.tech, sys.demand)
+}
+
+// cycles returns the cycles of the valid links of the system. A cycle in
+// which the processes supply exactly what they demand from each other makes
+// the system singular.
+func (sys *modelSystem) cycles() [][]int {
+	graph := make(map[int][]int)
+	for _, link := range sys.links {
+		graph[link.provider] = append(graph[link.provider], link.recipient)
+	}
+	return findCycles(sys.ids, graph)
+}
+
+// refAmount returns the amount of the reference flow of the reference
+// process; it returns 1 if the reference flow could not be found.
+func (sys *modelSystem) refAmount() float64 {
+	refFlow := sys.info.refFlows[sys.refID]
+	for _, e := range sys.info.exchanges[sys.refID] {
+		if e.InternalID == refFlow && e.Amount != 0 {
+			return e.Amount
+		}
+	}
+	return 1
+}
+
+// amount returns the sum of the amounts of the exchanges of the given process
+// with the given flow and direction.
+func (sys *modelSystem) amount(id int, flow, direction string) float64 {
+	sum := 0.0
+	for _, e := range sys.info.exchanges[id] {
+		if e.FlowID == flow && strings.EqualFold(e.Direction, direction) {
+			sum += e.Amount
+		}
+	}
+	return sum
+}
+
+// linkDemand returns the unscaled amount that the recipient of the given link
+// demands from the provider.
+func (sys *modelSystem) linkDemand(link *modelLink) float64 {
+	amount := sys.amount(link.recipient, link.inputFlow, "Input")
+	if n := sys.providers[inputKey(link.recipient, link.inputFlow)]; n > 1 {
+		amount /= float64(n)
+	}
+	return amount
+}
+
+// scaling returns the scaling factor of the process with the given internal
+// ID from the given solution.
+func (sys *modelSystem) scaling(solution []float64, id int) float64 {
+	i, ok := sys.index[id]
+	if !ok {
+		return 0
+	}
+	return solution[i]
+}
+
+// findCycles returns the cycles of the given directed graph as lists of
+// nodes via depth first searches from each node. Each cycle is returned only
+// once.
+func findCycles(nodes []int, graph map[int][]int) [][]int {
+	var cycles [][]int
+	seen := make(map[string]bool)
+	state := make(map[int]int) // 0 = new, 1 = on stack, 2 = done
+	var stack []int
+	var visit func(node int)
+	visit = func(node int) {
+		state[node] = 1
+		stack = append(stack, node)
+		for _, next := range graph[node] {
+			switch state[next] {
+			case 0:
+				visit(next)
+			case 1:
+				start := 0
+				for i, n := range stack {
+					if n == next {
+						start = i
+						break
+					}
+				}
+				cycle := append([]int{}, stack[start:]...)
+				key := cycleKey(cycle)
+				if !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[node] = 2
+	}
+	for _, node := range nodes {
+		if state[node] == 0 {
+			visit(node)
+		}
+	}
+	return cycles
+}
+
+func cycleKey(cycle []int) string {
+	sorted := append([]int{}, cycle...)
+	sort.Ints(sorted)
+	parts := make([]string, len(sorted))
+	for i, id := range sorted {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// cycleString formats the given cycle as `1 -> 2 -> 1`.
+func cycleString(cycle []int) string {
+	parts := make([]string, 0, len(cycle)+1)
+	for _, id := range cycle {
+		parts = append(parts, strconv.Itoa(id))
+	}
+	parts = append(parts, strconv.Itoa(cycle[0]))
+	return strings.Join(parts, " -> ")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/msrocka/ilcd"
+)
+
+// PackageReader reads the data sets of an ILCD package. The package can be a
+// zip file or an unpacked folder. Folders are packed into a temporary zip file
+// so that they can be processed exactly like zip packages.
+type PackageReader struct {
+	*ilcd.ZipReader
+
+	// the path of the zip file; this is the temporary zip file if the package
+	// is a folder
+	zipPath string
+
+	// the path of the temporary zip file if the package is a folder
+	tempFile string
+}
+
+// OpenPackage opens the ILCD package (a zip file or a folder) at the given
+// path.
+func OpenPackage(path string) (*PackageReader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		reader, err := ilcd.NewZipReader(path)
+		if err != nil {
+			return nil, err
+		}
+		return &PackageReader{ZipReader: reader, zipPath: path}, nil
+	}
+
+	tempFile, err := packFolder(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := ilcd.NewZipReader(tempFile)
+	if err != nil {
+		os.Remove(tempFile)
+		return nil, err
+	}
+	return &PackageReader{ZipReader: reader, zipPath: tempFile,
+		tempFile: tempFile}, nil
+}
+
+// ZipPath returns the path of the zip file of the package. The entries of
+// that file can be read directly as streams, e.g. via `archive/zip`.
+func (r *PackageReader) ZipPath() string {
+	return r.zipPath
+}
+
+// Close closes the package and deletes the temporary zip file if the package
+// is a folder.
+func (r *PackageReader) Close() error {
+	err := r.ZipReader.Close()
+	if r.tempFile != "" {
+		if rerr := os.Remove(r.tempFile); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// packFolder writes the files of the given package folder into a temporary
+// zip file and returns the path of that file. If the folder does not contain
+// an `ILCD` sub-folder, it is expected that the folder itself is the `ILCD`
+// folder of the package.
+func packFolder(folder string) (string, error) {
+	f, err := ioutil.TempFile("", "peflocus_*.zip")
+	if err != nil {
+		return "", err
+	}
+	tempFile := f.Name()
+	f.Close()
+
+	writer, err := ilcd.NewZipWriter(tempFile)
+	if err != nil {
+		os.Remove(tempFile)
+		return "", err
+	}
+
+	prefix := ""
+	if !hasILCDFolder(folder) {
+		prefix = "ILCD/"
+	}
+	log.Println("INFO: Read package folder", folder)
+	count := 0
+	err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(folder, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		count++
+		return writer.Write(prefix+filepath.ToSlash(rel), data)
+	})
+	if cerr := writer.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tempFile)
+		return "", err
+	}
+	log.Println(" ... read", count, "files")
+	return tempFile, nil
+}
+
+// IsPackageFolder returns true if the given folder is an unpacked ILCD
+// package; that is if it contains an `ILCD` folder or data set folders like
+// `processes` or `flows`.
+func IsPackageFolder(folder string) bool {
+	if hasILCDFolder(folder) {
+		return true
+	}
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		name := strings.ToLower(file.Name())
+		for _, t := range ilcd.DataSetTypes() {
+			if name == t.Folder() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasILCDFolder(folder string) bool {
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		if file.IsDir() && strings.ToLower(file.Name()) == "ilcd" {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputPath returns the path of the zip file that is created for the package
+// with the given name in the working directory. The name of the output file
+// is the name of the package with the given prefix. It is always a zip file,
+// also when the package is a folder.
+func OutputPath(workdir, name, prefix string) string {
+	dir, base := filepath.Split(name)
+	base = strings.TrimSuffix(base, ".zip")
+	return filepath.Join(workdir, dir, prefix+base+".zip")
+}
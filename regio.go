@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// exchangeUsage describes the usage of a (flow, direction, location) triple
+// in the elementary exchanges of the processes of a package.
+type exchangeUsage struct {
+	exchange *FlowAmount
+
+	// the number of exchanges with this triple
+	count int
+
+	// the UUID of the first process that has such an exchange
+	process string
+}
+
+// checkRegionalization cross-checks the elementary exchanges of the processes
+// with the characterization factors of the LCIA methods in the packages of
+// the working directory. For each method, it reports the exchanges that have
+// a location for which no factor exists (misses), the exchanges for which the
+// unregionalized factor is used (fallbacks), and the factors that are never
+// matched by an exchange.
+func checkRegionalization(args *Args) {
+	format := strings.ToLower(args.Format)
+	if !IsValidFormat(format) {
+		log.Fatalln("ERROR: Unknown format", args.Format)
+	}
+	var methodIDs map[string]bool
+	if args.Methods != "" {
+		methodIDs = readUUIDs(args.Methods)
+	}
+	report := &Report{}
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		log.Println("INFO: check regionalization in", path)
+		usages := collectUsages(reader.ZipReader)
+		for _, m := range readMethods(reader.ZipReader, methodIDs) {
+			subject := report.NewSubject(name, ilcd.MethodDataSet.Folder(),
+				m.UUID, m.Name)
+			checkMethodRegionalization(m, usages, subject)
+		}
+		reader.Close()
+	}
+	if err := report.Write(os.Stdout, format); err != nil {
+		log.Fatalln("ERROR: Failed to write report", err)
+	}
+}
+
+// collectUsages collects the distinct (flow, direction, location) triples
+// of the elementary exchanges in the processes of the given package, sorted
+// by their keys.
+func collectUsages(reader *ilcd.ZipReader) []*exchangeUsage {
+	flows := NewFlowIndex(reader)
+	index := make(map[string]*exchangeUsage)
+	unknown := 0
+	eachProcessDoc(reader, func(doc *etree.Document) {
+		process := DataSetUUID(doc)
+		for _, e := range ReadExchanges(doc) {
+			flow := flows.Get(e.FlowID)
+			if flow == nil {
+				unknown++
+				continue
+			}
+			if !flow.IsElementary() {
+				continue
+			}
+			key := e.Key()
+			usage := index[key]
+			if usage == nil {
+				usage = &exchangeUsage{exchange: e, process: process}
+				index[key] = usage
+			}
+			usage.count++
+		}
+	})
+	if unknown > 0 {
+		log.Println(" ... WARNING: ignored", unknown,
+			"exchanges with flows that are not contained in the package")
+	}
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	usages := make([]*exchangeUsage, 0, len(keys))
+	for _, key := range keys {
+		usages = append(usages, index[key])
+	}
+	return usages
+}
+
+func checkMethodRegionalization(m *MethodFactors, usages []*exchangeUsage,
+	subject *Subject) {
+
+	// <flow>/<direction>/<location> of the matched factors
+	matched := make(map[string]bool)
+	exact, fallbacks, misses := 0, 0, 0
+	for _, usage := range usages {
+		e := usage.exchange
+		if !m.Characterizes(e.FlowID, e.Direction) {
+			continue
+		}
+		_, match := m.Match(e)
+		switch match {
+		case matchExact:
+			exact += usage.count
+			matched[factorKey(e.FlowID, e.Direction)+"/"+NormKey(e.Location)] = true
+		case matchFallback, matchGlobal:
+			fallbacks += usage.count
+			used, kind := "", "unregionalized"
+			if match == matchGlobal {
+				used, kind = "glo", "GLO"
+			}
+			matched[factorKey(e.FlowID, e.Direction)+"/"+used] = true
+			f := subject.Info("no factor for flow", e.FlowID, e.FlowName,
+				"("+e.Direction+") in location", "'"+e.Location+"';",
+				"the", kind, "factor is used for", usage.count,
+				"exchange(s), e.g. in process", usage.process)
+			f.Process = usage.process
+			f.Flow = e.FlowID
+			f.Location = e.Location
+		default:
+			misses += usage.count
+			f := subject.Warning("no factor for flow", e.FlowID, e.FlowName,
+				"("+e.Direction+") in location", "'"+e.Location+"'",
+				"and no unregionalized or GLO factor; missed in", usage.count,
+				"exchange(s), e.g. in process", usage.process)
+			f.Process = usage.process
+			f.Flow = e.FlowID
+			f.Location = e.Location
+		}
+	}
+
+	// the factors that were never matched
+	var keys []string
+	for key, locations := range m.factors {
+		for location := range locations {
+			if !matched[key+"/"+location] {
+				keys = append(keys, key+"/"+location)
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 3)
+		location := strings.ToUpper(parts[2])
+		f := subject.Info("the factor for flow", parts[0], "("+parts[1]+
+			") in location", "'"+location+"'", "is never matched")
+		f.Flow = parts[0]
+		f.Location = location
+	}
+
+	subject.Info("checked the elementary exchanges:", exact, "exact matches,",
+		fallbacks, "fallbacks,", misses, "misses,", len(keys),
+		"factors never matched")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The severities of findings.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Report collects the findings of checks on the data sets of ILCD packages.
+type Report struct {
+	Subjects []*Subject
+}
+
+// Subject is a checked data set (e.g. a life cycle model) with its findings.
+type Subject struct {
+	Package  string
+	Type     string
+	UUID     string
+	Name     string
+	Findings []*Finding
+}
+
+// Finding is a problem or information found when checking a data set.
+type Finding struct {
+	Severity string
+
+	// the data set internal ID of the process instance in a life cycle model
+	// or of an exchange in a process
+	InternalID string
+
+	// the UUID of the process to which the finding is related
+	Process string
+
+	// the UUID of the flow to which the finding is related
+	Flow string
+
+	// the location code of an exchange or factor to which the finding is
+	// related
+	Location string
+
+	// the line and column in the XML document of the data set to which the
+	// finding is related; 0 if unknown
+	Line   int
+	Column int
+
+	Message string
+}
+
+// findingRecord is the flat JSON representation of a finding.
+type findingRecord struct {
+	Severity   string `json:"severity"`
+	Package    string `json:"package"`
+	Type       string `json:"type"`
+	UUID       string `json:"uuid"`
+	Name       string `json:"name,omitempty"`
+	InternalID string `json:"internalId,omitempty"`
+	Process    string `json:"process,omitempty"`
+	Flow       string `json:"flow,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Column     int    `json:"column,omitempty"`
+	Message    string `json:"message"`
+}
+
+// NewSubject creates a new subject and adds it to the report.
+func (r *Report) NewSubject(pack, dsType, uuid, name string) *Subject {
+	s := &Subject{Package: pack, Type: dsType, UUID: uuid, Name: name}
+	r.Subjects = append(r.Subjects, s)
+	return s
+}
+
+// Add adds the given finding to the subject.
+func (s *Subject) Add(f *Finding) {
+	s.Findings = append(s.Findings, f)
+}
+
+// Error adds an error with the given message parts to the subject and
+// returns it, so that further fields can be set.
+func (s *Subject) Error(msg ...interface{}) *Finding {
+	return s.add(SeverityError, msg)
+}
+
+// Warning adds a warning with the given message parts to the subject.
+func (s *Subject) Warning(msg ...interface{}) *Finding {
+	return s.add(SeverityWarning, msg)
+}
+
+// Info adds an information with the given message parts to the subject.
+func (s *Subject) Info(msg ...interface{}) *Finding {
+	return s.add(SeverityInfo, msg)
+}
+
+func (s *Subject) add(severity string, msg []interface{}) *Finding {
+	f := &Finding{
+		Severity: severity,
+		Message:  strings.TrimSuffix(fmt.Sprintln(msg...), "\n")}
+	s.Add(f)
+	return f
+}
+
+// Count returns the number of findings with the given severity.
+func (s *Subject) Count(severity string) int {
+	count := 0
+	for _, f := range s.Findings {
+		if f.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// IsValidFormat returns true if the given report format is supported.
+func IsValidFormat(format string) bool {
+	switch format {
+	case "", "text", "json", "junit":
+		return true
+	}
+	return false
+}
+
+// WriteText writes the findings of the given subject as text.
+func WriteText(w io.Writer, s *Subject) {
+	for _, f := range s.Findings {
+		fmt.Fprintln(w, "  ..", f.Severity+":", f.Text())
+	}
+}
+
+// Text returns the message of the finding prefixed with its position in the
+// document if it is known.
+func (f *Finding) Text() string {
+	if f.Line <= 0 {
+		return f.Message
+	}
+	return fmt.Sprintf("[%d:%d] %s", f.Line, f.Column, f.Message)
+}
+
+// Write writes the report in the given format (json or junit) to the given
+// writer. For the text format, the subjects are written via `WriteText`.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return r.writeJSON(w)
+	case "junit":
+		return r.writeJUnit(w)
+	default:
+		for _, s := range r.Subjects {
+			fmt.Fprintln(w, "\nCheck", s.Type, s.Name, s.UUID)
+			WriteText(w, s)
+		}
+		return nil
+	}
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	records := []*findingRecord{}
+	for _, s := range r.Subjects {
+		for _, f := range s.Findings {
+			records = append(records, &findingRecord{
+				Severity:   f.Severity,
+				Package:    s.Package,
+				Type:       s.Type,
+				UUID:       s.UUID,
+				Name:       s.Name,
+				InternalID: f.InternalID,
+				Process:    f.Process,
+				Flow:       f.Flow,
+				Location:   f.Location,
+				Line:       f.Line,
+				Column:     f.Column,
+				Message:    f.Message})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+type junitSuites struct {
+	XMLName xml.Name      `xml:"testsuites"`
+	Suites  []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the report as JUnit XML: a test suite for each package
+// and a test case for each subject. The errors of a subject are written as
+// failure of the test case; warnings and infos to the system output.
+func (r *Report) writeJUnit(w io.Writer) error {
+	suites := &junitSuites{}
+	index := make(map[string]*junitSuite)
+	for _, s := range r.Subjects {
+		suite := index[s.Package]
+		if suite == nil {
+			suite = &junitSuite{Name: s.Package}
+			index[s.Package] = suite
+			suites.Suites = append(suites.Suites, suite)
+		}
+		c := &junitCase{
+			Name:      s.Name + " (" + s.UUID + ")",
+			ClassName: s.Type}
+		var errors, others []string
+		for _, f := range s.Findings {
+			line := f.Severity + ": " + f.Text()
+			if f.Severity == SeverityError {
+				errors = append(errors, line)
+			} else {
+				others = append(others, line)
+			}
+		}
+		if len(errors) > 0 {
+			c.Failure = &junitFailure{
+				Message: fmt.Sprint(len(errors), " error(s) found"),
+				Type:    SeverityError,
+				Text:    strings.Join(errors, "\n")}
+			suite.Failures++
+		}
+		c.SystemOut = strings.Join(others, "\n")
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
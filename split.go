@@ -0,0 +1,358 @@
+package main
+
+import (
+	"archive/zip"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/msrocka/ilcd"
+)
+
+// Splitter splits the ILCD packages in a working directory into smaller
+// packages.
+type Splitter struct {
+	workdir   string
+	recursive bool
+
+	// the split mode: type, classification, count, size, or process
+	by string
+
+	// the maximum number of entries (mode count) or compressed bytes (mode
+	// size) of a package
+	max int64
+
+	// zip entry -> compressed size of the entries of the current package;
+	// used in mode size
+	sizes map[string]int64
+
+	// the number of classification levels that are used in mode
+	// classification
+	level int
+}
+
+// NewSplitter initializes a new splitter from the given arguments.
+func NewSplitter(args *Args) *Splitter {
+	s := &Splitter{
+		workdir:   args.WorkDir,
+		recursive: args.IsRecursive(),
+		by:        strings.ToLower(args.By),
+		level:     1}
+	if s.by == "" {
+		s.by = "type"
+	}
+	switch s.by {
+	case "type", "classification", "process":
+	case "count", "size":
+		if args.Max == "" {
+			log.Fatalln("ERROR: no maximum given; use the -max option")
+		}
+		max, err := parseSize(args.Max)
+		if err != nil || max <= 0 {
+			log.Fatalln("ERROR: invalid maximum", args.Max)
+		}
+		s.max = max
+	default:
+		log.Fatalln("ERROR: unknown split mode", args.By)
+	}
+	if args.Level != "" {
+		level, err := strconv.Atoi(args.Level)
+		if err != nil || level < 1 {
+			log.Fatalln("ERROR: invalid classification level", args.Level)
+		}
+		s.level = level
+	}
+	return s
+}
+
+// Run executes the package splitting.
+func (s *Splitter) Run() {
+	for _, name := range GetPackageNames(s.workdir, s.recursive) {
+		path := filepath.Join(s.workdir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Failed to read package", path, ":", err)
+			continue
+		}
+		log.Println("INFO: split package", path, "by", s.by)
+		if s.by == "size" {
+			s.sizes = compressedSizes(reader.ZipPath())
+		}
+		parts := s.parts(reader.ZipReader)
+		for _, part := range parts {
+			s.write(name, part)
+		}
+		log.Println(" ... created", len(parts), "packages")
+		if err = reader.Close(); err != nil {
+			log.Println("ERROR: Failed to close package", path, ":", err)
+		}
+	}
+}
+
+// splitPart is a set of zip entries that are written into a package.
+type splitPart struct {
+	name  string
+	files []*ilcd.ZipFile
+}
+
+func (s *Splitter) parts(reader *ilcd.ZipReader) []*splitPart {
+	switch s.by {
+	case "classification":
+		return withShared(s.byClassification(reader), sharedEntries(reader))
+	case "count", "size":
+		return s.byMax(reader)
+	case "process":
+		return withShared(s.byProcess(reader), sharedEntries(reader))
+	default:
+		return s.byType(reader)
+	}
+}
+
+// sharedEntries returns the entries of the package that are not data sets,
+// like external documents, images, or files in `META-INF`. In the modes
+// process and classification, these entries are copied into each package.
+func sharedEntries(reader *ilcd.ZipReader) []*ilcd.ZipFile {
+	var shared []*ilcd.ZipFile
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		path := zipFile.Path()
+		if !strings.HasSuffix(path, "/") && !IsDataSetEntry(path) {
+			shared = append(shared, zipFile)
+		}
+		return true
+	})
+	return shared
+}
+
+// withShared adds the given shared entries to each part if they are not
+// already contained in it.
+func withShared(parts []*splitPart, shared []*ilcd.ZipFile) []*splitPart {
+	for _, part := range parts {
+		contained := make(map[string]bool)
+		for _, file := range part.files {
+			contained[file.Path()] = true
+		}
+		for _, file := range shared {
+			if !contained[file.Path()] {
+				part.files = append(part.files, file)
+			}
+		}
+	}
+	return parts
+}
+
+// compressedSizes returns the compressed sizes of the entries in the given
+// zip file.
+func compressedSizes(path string) map[string]int64 {
+	sizes := make(map[string]int64)
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		log.Println("ERROR: Failed to read entry sizes of", path, err)
+		return sizes
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		sizes[f.Name] = int64(f.CompressedSize64)
+	}
+	return sizes
+}
+
+func (s *Splitter) byType(reader *ilcd.ZipReader) []*splitPart {
+	parts := make(map[string]*splitPart)
+	var names []string
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		name := "other"
+		if t := zipFile.Type(); t >= 0 && t != ilcd.Asset && t.Folder() != "" {
+			name = t.Folder()
+		}
+		part := parts[name]
+		if part == nil {
+			part = &splitPart{name: name}
+			parts[name] = part
+			names = append(names, name)
+		}
+		part.files = append(part.files, zipFile)
+		return true
+	})
+	sort.Strings(names)
+	list := make([]*splitPart, 0, len(names))
+	for _, name := range names {
+		list = append(list, parts[name])
+	}
+	return list
+}
+
+// byClassification puts the processes of the same class together with their
+// dependencies into a package. All other entries that are not used by a
+// process are put into a separate package.
+func (s *Splitter) byClassification(reader *ilcd.ZipReader) []*splitPart {
+	classes := make(map[string][]*ilcd.ZipFile)
+	var others []*ilcd.ZipFile
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		if zipFile.Type() != ilcd.ProcessDataSet ||
+			!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+			others = append(others, zipFile)
+			return true
+		}
+		data, err := zipFile.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read process", zipFile.Path(), err)
+			return true
+		}
+		path := Classification(data)
+		if len(path) > s.level {
+			path = path[:s.level]
+		}
+		class := "unclassified"
+		if len(path) > 0 {
+			class = strings.Join(path, "_")
+		}
+		classes[class] = append(classes[class], zipFile)
+		return true
+	})
+
+	deps := NewDependencies(reader)
+	used := make(map[string]bool)
+	var names []string
+	for class := range classes {
+		names = append(names, class)
+	}
+	sort.Strings(names)
+	var parts []*splitPart
+	for _, class := range names {
+		files := deps.Closure(classes[class]...)
+		for _, file := range files {
+			used[file.Path()] = true
+		}
+		parts = append(parts, &splitPart{name: class, files: files})
+	}
+
+	var unused []*ilcd.ZipFile
+	for _, file := range others {
+		if !used[file.Path()] {
+			unused = append(unused, file)
+		}
+	}
+	if len(unused) > 0 {
+		parts = append(parts, &splitPart{name: "other", files: unused})
+	}
+	return parts
+}
+
+// byMax puts the entries in their order into packages with a maximum number
+// of entries or bytes. In mode size, the compressed sizes of the entries are
+// used so that the created packages are close to the maximum size.
+func (s *Splitter) byMax(reader *ilcd.ZipReader) []*splitPart {
+	var parts []*splitPart
+	var current *splitPart
+	var size int64
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		// directory entries are not counted as data sets
+		if strings.HasSuffix(zipFile.Path(), "/") {
+			return true
+		}
+		var n int64 = 1
+		if s.by == "size" {
+			compressed, ok := s.sizes[zipFile.Path()]
+			if !ok {
+				data, err := zipFile.Read()
+				if err != nil {
+					log.Println("ERROR: Failed to read entry", zipFile.Path(), err)
+					return true
+				}
+				compressed = int64(len(data))
+			}
+			n = compressed
+			if n > s.max {
+				log.Println("WARNING: entry", zipFile.Path(),
+					"is larger than the maximum package size")
+			}
+		}
+		if current == nil || (size+n > s.max && len(current.files) > 0) {
+			current = &splitPart{name: strconv.Itoa(len(parts) + 1)}
+			parts = append(parts, current)
+			size = 0
+		}
+		current.files = append(current.files, zipFile)
+		size += n
+		return true
+	})
+	return parts
+}
+
+// byProcess creates a package for each process that contains the process
+// and all of its dependencies.
+func (s *Splitter) byProcess(reader *ilcd.ZipReader) []*splitPart {
+	deps := NewDependencies(reader)
+	var parts []*splitPart
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		if zipFile.Type() != ilcd.ProcessDataSet ||
+			!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+			return true
+		}
+		name := strings.TrimSuffix(filepath.Base(zipFile.Path()), ".xml")
+		parts = append(parts, &splitPart{
+			name:  name,
+			files: deps.Closure(zipFile)})
+		return true
+	})
+	return parts
+}
+
+func (s *Splitter) write(name string, part *splitPart) {
+	path := strings.TrimSuffix(OutputPath(s.workdir, name, "peflocus_"), ".zip") +
+		"_" + fileName(part.name) + ".zip"
+	DeleteExisting(path)
+	writer, err := ilcd.NewZipWriter(path)
+	if err != nil {
+		log.Println("ERROR: Failed to create zip writer for", path, ":", err)
+		return
+	}
+	defer writer.Close()
+	for _, file := range part.files {
+		data, err := file.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read entry", file.Path(), err)
+			continue
+		}
+		if err := writer.Write(file.Path(), data); err != nil {
+			log.Println("ERROR: Failed to write entry", file.Path(), err)
+		}
+	}
+	log.Println("INFO: wrote", len(part.files), "entries to", path)
+}
+
+// fileName replaces the characters of the given name that should not be used
+// in file names.
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// parseSize parses the given size value which is a number with an optional
+// unit suffix (KB, MB, GB).
+func parseSize(val string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(val))
+	factor := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		factor int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}} {
+		if strings.HasSuffix(v, unit.suffix) {
+			factor = unit.factor
+			v = strings.TrimSpace(strings.TrimSuffix(v, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * factor, nil
+}
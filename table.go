@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// exportTable writes the exchanges of the processes and the characterization
+// factors of the LCIA methods of the packages in the working directory into
+// CSV files in long format, one row per exchange or factor:
+// `peflocus_exchanges_<package>.csv` and `peflocus_factors_<package>.csv`.
+func exportTable(args *Args) {
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		log.Println("INFO: Export tables of", path)
+		flows := NewFlowIndex(reader.ZipReader)
+		base := strings.TrimSuffix(name, ".zip")
+		writeExchangeTable(reader.ZipReader, flows,
+			filepath.Join(args.WorkDir, tablePath(base, "peflocus_exchanges_")))
+		writeFactorTable(reader.ZipReader, flows,
+			filepath.Join(args.WorkDir, tablePath(base, "peflocus_factors_")))
+		reader.Close()
+	}
+}
+
+// tablePath returns the path of the CSV file for the given package name
+// relative to the working directory.
+func tablePath(name, prefix string) string {
+	dir, base := filepath.Split(name)
+	return filepath.Join(dir, prefix+base+".csv")
+}
+
+func writeExchangeTable(reader *ilcd.ZipReader, flows *FlowIndex, path string) {
+	rows := [][]string{{"Process UUID", "Process", "Flow UUID", "Flow",
+		"Direction", "Location", "Amount", "Unit"}}
+	eachProcessDoc(reader, func(doc *etree.Document) {
+		uuid, name := DataSetUUID(doc), DataSetName(doc)
+		for _, e := range ReadExchanges(doc) {
+			flowName, unit := tableFlowInfo(e, flows)
+			rows = append(rows, []string{uuid, name, e.FlowID, flowName,
+				e.Direction, e.Location, strconv.FormatFloat(e.Amount, 'g', -1, 64),
+				unit})
+		}
+	})
+	writeTable(rows, path, "exchanges")
+}
+
+func writeFactorTable(reader *ilcd.ZipReader, flows *FlowIndex, path string) {
+	rows := [][]string{{"Method UUID", "Method", "Flow UUID", "Flow",
+		"Direction", "Location", "Value", "Unit"}}
+	eachMethodDoc(reader, func(doc *etree.Document) {
+		uuid, name := DataSetUUID(doc), DataSetName(doc)
+
+		// the unit of a factor is <reference unit of the method> per
+		// <reference unit of the flow>
+		methodUnit := ""
+		ref := doc.FindElement(
+			"./LCIAMethodDataSet/LCIAMethodInformation/quantitativeReference/referenceQuantity")
+		if ref != nil {
+			methodUnit = childText(ref, "./shortDescription")
+		}
+		for _, f := range ReadFactors(doc) {
+			flowName, flowUnit := tableFlowInfo(f, flows)
+			unit := methodUnit
+			if flowUnit != "" {
+				unit += "/" + flowUnit
+			}
+			rows = append(rows, []string{uuid, name, f.FlowID, flowName,
+				f.Direction, f.Location, strconv.FormatFloat(f.Amount, 'g', -1, 64),
+				unit})
+		}
+	})
+	writeTable(rows, path, "factors")
+}
+
+// tableFlowInfo returns the name and reference unit of the flow of the given
+// exchange or factor. The name of the flow data set is preferred over the
+// short description of the flow reference.
+func tableFlowInfo(a *FlowAmount, flows *FlowIndex) (string, string) {
+	flow := flows.Get(a.FlowID)
+	if flow == nil {
+		return a.FlowName, ""
+	}
+	if flow.Name == "" {
+		return a.FlowName, flow.Unit
+	}
+	return flow.Name, flow.Unit
+}
+
+func writeTable(rows [][]string, path, what string) {
+	if len(rows) < 2 {
+		log.Println(" ... no", what, "found")
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Println("ERROR: Failed to create file", path, err)
+		return
+	}
+	defer f.Close()
+	writer := csv.NewWriter(f)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		log.Println("ERROR: Failed to write", what, "to", path, err)
+		return
+	}
+	log.Println(" ... wrote", len(rows)-1, what, "to", path)
+}
@@ -3,112 +3,49 @@ package main
 import (
 	"log"
 	"path/filepath"
-	"strings"
-
-	"github.com/msrocka/ilcd"
 )
 
 // FlowUnmapper reverses an applied mapping
 type FlowUnmapper struct {
-	workdir string
-	mapfile string
+	workdir   string
+	mapfile   string
+	hierarchy string
+	recursive bool
+	locNames  bool
 
 	flowMap *FlowMap
 }
 
 // NewFlowUnmapper initializes a new flow unmapper from the given arguments.
 func NewFlowUnmapper(args *Args) *FlowUnmapper {
-	return &FlowUnmapper{workdir: args.WorkDir, mapfile: args.MapFile}
+	return &FlowUnmapper{
+		workdir:   args.WorkDir,
+		mapfile:   args.MapFile,
+		hierarchy: args.Hierarchy,
+		recursive: args.IsRecursive(),
+		locNames:  isTrue(args.LocNames)}
 }
 
 // Run executes the flow un-mapping.
 func (u *FlowUnmapper) Run() {
 	u.flowMap = ReadFlowMap(u.mapfile)
-	zips := GetZipNames(u.workdir)
-	for _, name := range zips {
+	if u.hierarchy != "" {
+		u.flowMap.SetHierarchy(ReadLocationHierarchy(u.hierarchy))
+	}
+	packages := GetPackageNames(u.workdir, u.recursive)
+	for _, name := range packages {
 		sourcePath := filepath.Join(u.workdir, name)
-		targetPath := filepath.Join(u.workdir, "peflocus_unmapped_"+name)
+		targetPath := OutputPath(u.workdir, name, "peflocus_unmapped_")
 		DeleteExisting(targetPath)
-		log.Println("INFO: map flows in", sourcePath, "to", targetPath)
+		log.Println("INFO: unmap flows in", sourcePath, "to", targetPath)
 		u.doIt(sourcePath, targetPath)
 	}
 }
 
 func (u *FlowUnmapper) doIt(sourcePath, targetPath string) {
-
-	// create the reader and writer
-	reader, err := ilcd.NewZipReader(sourcePath)
-	if err != nil {
-		log.Println("ERROR: Failed to read zip", sourcePath, ":", err)
-		return
-	}
-	defer reader.Close()
-	writer, err := ilcd.NewZipWriter(targetPath)
-	if err != nil {
-		log.Println("ERROR: Failed to create zip writer for",
-			targetPath, ":", err)
-		return
-	}
-	defer writer.Close()
-
-	// unmap the flows in the data sets
-	flowFolder := ""
-	reader.Map(writer, func(zipFile *ilcd.ZipFile) (string, []byte) {
-		path := zipFile.Path()
-		if flowFolder == "" && ilcd.IsFlowPath(path) {
-			flowFolder = strings.Split(path, "flows")[0] + "flows/"
-		}
-		if zipFile.Type() == ilcd.FlowDataSet {
-			return "", nil // flows are filtered & written later
-		}
-		data, err := zipFile.Read()
-		if err != nil {
-			log.Println("ERROR: Failed to read entry", path, err)
-			return "", nil
-		}
-		converted, err := u.flowMap.UnmapFlows(path, data)
-		if err != nil {
-			log.Println("ERROR: Failed to umap flows in", path, err)
-			return "", nil
-		}
-		return path, converted
-	})
-
-	gen := FlowGenerator{
+	convertPackage(sourcePath, targetPath, &FlowGenerator{
 		flowMap:   u.flowMap,
-		folder:    flowFolder,
-		reader:    reader,
-		writer:    writer,
-		forMapped: false}
-	gen.Generate()
-
-	// copy the flows that were not mapped but are used
-	log.Println("INFO: Copy untouched but used flows")
-	count := 0
-	reader.Map(writer, func(zipFile *ilcd.ZipFile) (string, []byte) {
-		if zipFile.Type() != ilcd.FlowDataSet {
-			return "", nil
-		}
-		data, err := zipFile.Read()
-		if err != nil {
-			log.Println("ERROR: Failed to read flow", zipFile.Path())
-			return "", nil
-		}
-		flow, err := zipFile.ReadFlow()
-		if err != nil {
-			log.Println("ERROR: Failed to read flow", zipFile.Path())
-			return "", nil
-		}
-		uuid := flow.UUID()
-		if !u.flowMap.untouchedUsed[uuid] {
-			// skip all flows that where mapped or that are not used
-			return "", nil
-		}
-		path := flowFolder + uuid + "_" + flow.Version() + ".xml"
-		count++
-		return path, data
-	})
-	log.Println(" ... copied", count, "flows")
-
+		locNames:  u.locNames,
+		forMapped: false})
 	u.flowMap.ResetStats()
 }
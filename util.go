@@ -1,9 +1,11 @@
 package main
 
 import (
-	"io/ioutil"
+	"crypto/sha1"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/msrocka/ilcd"
@@ -21,30 +23,64 @@ func DeleteExisting(file string) {
 	}
 }
 
-// GetZipNames returns the names of the zip files in the given folder. It
-// excludes all zip files that start with the prefix `peflocus_`.
-func GetZipNames(folder string) []string {
-	zips, err := ioutil.ReadDir(folder)
-	if err != nil {
-		log.Println("ERROR: Failed to read zip files from folder", folder, err)
-		return nil
-	}
-
-	log.Println("INFO: Get zip files from", folder)
+// GetPackageNames returns the names of the ILCD packages in the given folder.
+// A package is a zip file or an unpacked package folder (see
+// `IsPackageFolder`). The names are the paths of the packages relative to the
+// given folder. If `recursive` is true, the sub-folders are searched for
+// packages too. It excludes all packages that start with the prefix
+// `peflocus_`. Sub-folders that cannot be read are reported and skipped.
+func GetPackageNames(folder string, recursive bool) []string {
+	log.Println("INFO: Get packages from", folder)
 	var names []string
-	for _, zip := range zips {
-		name := zip.Name()
-		if zip.IsDir() || !strings.HasSuffix(name, ".zip") {
-			log.Println(" ... ignore file", name)
-			continue
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == folder {
+				return err
+			}
+			log.Println("ERROR: Failed to read", path, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path == folder {
+			return nil
+		}
+		name := info.Name()
+		rel, err := filepath.Rel(folder, path)
+		if err != nil {
+			return err
 		}
 		if strings.HasPrefix(name, "peflocus_") {
-			log.Println(" ... ignore file", name, "(this may be overwritten)")
-			continue
+			log.Println(" ... ignore file", rel, "(this may be overwritten)")
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		names = append(names, name)
+		if info.IsDir() {
+			if IsPackageFolder(path) {
+				names = append(names, rel)
+				return filepath.SkipDir
+			}
+			if !recursive {
+				log.Println(" ... ignore folder", rel)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(strings.ToLower(name), ".zip") {
+			log.Println(" ... ignore file", rel)
+			return nil
+		}
+		names = append(names, rel)
+		return nil
+	})
+	if err != nil {
+		log.Println("ERROR: Failed to read packages from folder", folder, err)
+		return nil
 	}
-	log.Println(" ... found", len(names), "files")
+	log.Println(" ... found", len(names), "packages")
 	return names
 }
 
@@ -62,7 +98,27 @@ func GetPathType(path string) ilcd.DataSetType {
 	return -1
 }
 
+// ExternalDocName returns the name of the external document with the given
+// path; it is the part of the path after the external documents folder. An
+// empty string is returned if the path is not in such a folder.
+func ExternalDocName(path string) string {
+	parts := strings.Split(path, ilcd.ExternalDoc.Folder())
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimLeft(parts[1], "/\\")
+}
+
 // NormKey normalizes the given key.
 func NormKey(key string) string {
 	return strings.ToLower(strings.TrimSpace(key))
 }
+
+// NameUUID creates a name based UUID from the given name. The same name
+// always results in the same UUID.
+func NameUUID(name string) string {
+	h := sha1.Sum([]byte(name))
+	h[6] = (h[6] & 0x0f) | 0x50
+	h[8] = (h[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/msrocka/ilcd"
+)
+
+// rootElements contains the names of the root elements of the data set types
+// that are validated.
+var rootElements = map[ilcd.DataSetType]string{
+	ilcd.ContactDataSet:      "contactDataSet",
+	ilcd.SourceDataSet:       "sourceDataSet",
+	ilcd.UnitGroupDataSet:    "unitGroupDataSet",
+	ilcd.FlowPropertyDataSet: "flowPropertyDataSet",
+	ilcd.FlowDataSet:         "flowDataSet",
+	ilcd.ProcessDataSet:      "processDataSet",
+	ilcd.MethodDataSet:       "LCIAMethodDataSet",
+	ilcd.ModelDataSet:        "lifeCycleModelDataSet",
+}
+
+var uuidPattern = regexp.MustCompile(
+	`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var versionPattern = regexp.MustCompile(`^\d{2}\.\d{2}(\.\d{3})?$`)
+
+// validateCommand validates the data sets of the packages in the working
+// directory. The findings are reported in the same formats as in the
+// `model-check` command.
+func validateCommand(args *Args) {
+	format := strings.ToLower(args.Format)
+	if !IsValidFormat(format) {
+		log.Fatalln("ERROR: Unknown format", args.Format)
+	}
+	textMode := format == "" || format == "text"
+
+	// with `-schema true`, the schemas are loaded from the `ILCDFormat`
+	// folder of each package; otherwise from the given folder
+	var schemas *xsdSet
+	packageSchemas := isTrue(args.Schema)
+	if args.Schema != "" && !packageSchemas {
+		var err error
+		if schemas, err = folderSchemas(args.Schema); err != nil {
+			log.Fatalln("ERROR: Failed to load schemas from", args.Schema, err)
+		}
+	}
+
+	report := &Report{}
+	for _, name := range GetPackageNames(args.WorkDir, args.IsRecursive()) {
+		path := filepath.Join(args.WorkDir, name)
+		reader, err := OpenPackage(path)
+		if err != nil {
+			log.Println("ERROR: Could not read ILCD package", path)
+			continue
+		}
+		if textMode {
+			fmt.Println("\nValidate data sets in", path)
+		}
+		v := &validator{
+			reader:  reader.ZipReader,
+			flows:   NewFlowIndex(reader.ZipReader),
+			schemas: schemas}
+		if packageSchemas {
+			if v.schemas, err = readPackageSchemas(reader.ZipReader); err != nil {
+				log.Println("ERROR: Failed to load the schemas of", path, err)
+			}
+		}
+		count, errors, warnings := 0, 0, 0
+		reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+			if rootElements[zipFile.Type()] == "" ||
+				!strings.HasSuffix(strings.ToLower(zipFile.Path()), ".xml") {
+				return true
+			}
+			subject := report.NewSubject(name, zipFile.Type().Folder(), "",
+				zipFile.Path())
+			v.validate(zipFile, subject)
+			count++
+			errors += subject.Count(SeverityError)
+			warnings += subject.Count(SeverityWarning)
+			if textMode && len(subject.Findings) > 0 {
+				fmt.Println("\nValidate", subject.Type, subject.Name, subject.UUID)
+				WriteText(os.Stdout, subject)
+			}
+			return true
+		})
+		log.Println("INFO: validated", count, "data sets in", path, "with",
+			errors, "errors and", warnings, "warnings")
+		reader.Close()
+	}
+	if textMode {
+		return
+	}
+	if err := report.Write(os.Stdout, format); err != nil {
+		log.Fatalln("ERROR: Failed to write report", err)
+	}
+}
+
+// validator validates the data sets of a package.
+type validator struct {
+	reader *ilcd.ZipReader
+	flows  *FlowIndex
+
+	// the XML schemas against which the data sets are validated; nil if no
+	// schema validation should be done
+	schemas *xsdSet
+}
+
+// validate validates the given data set and adds the findings to the given
+// subject. The UUID and name of the subject are set from the data set.
+func (v *validator) validate(zipFile *ilcd.ZipFile, subject *Subject) {
+	data, err := zipFile.Read()
+	if err != nil {
+		subject.Error("failed to read", zipFile.Path(), err)
+		return
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		subject.Error("the data set is not valid XML:", err)
+		return
+	}
+	if v.schemas != nil {
+		v.validateSchema(data, subject)
+	}
+	t := zipFile.Type()
+	root := doc.Root()
+	if root == nil || root.Tag != rootElements[t] {
+		tag := ""
+		if root != nil {
+			tag = root.Tag
+		}
+		subject.Error("the root element", tag, "is not a", rootElements[t])
+		return
+	}
+
+	uuid := DataSetUUID(doc)
+	subject.UUID = uuid
+	if name := DataSetName(doc); name != "" {
+		subject.Name = name
+	} else {
+		subject.Error("the data set has no name")
+	}
+	v.validateUUID(zipFile.Path(), uuid, subject)
+	if version := DataSetVersion(doc); version == "" {
+		subject.Error("the data set has no version")
+	} else if !versionPattern.MatchString(version) {
+		subject.Warning("the data set version", version,
+			"has not the format `00.00.000`")
+	}
+
+	switch t {
+	case ilcd.ProcessDataSet:
+		v.validateProcess(doc, subject)
+	case ilcd.FlowDataSet:
+		v.validateFlow(doc, subject)
+	case ilcd.FlowPropertyDataSet:
+		v.validateFlowProperty(doc, subject)
+	case ilcd.UnitGroupDataSet:
+		if ReferenceUnit(doc) == "" {
+			subject.Error("the unit group has no reference unit")
+		}
+	case ilcd.MethodDataSet:
+		ref := doc.FindElement(
+			"./LCIAMethodDataSet/LCIAMethodInformation/quantitativeReference/referenceQuantity")
+		if ref == nil || strings.TrimSpace(ref.SelectAttrValue("refObjectId", "")) == "" {
+			subject.Error("the LCIA method has no reference quantity")
+		}
+		v.validateLocations(ReadFactors(doc), "factor", subject)
+	case ilcd.ModelDataSet:
+		ref := doc.FindElement(
+			"./lifeCycleModelDataSet/lifeCycleModelInformation/quantitativeReference/referenceToReferenceProcess")
+		if ref == nil || strings.TrimSpace(ref.Text()) == "" {
+			subject.Error("the life cycle model has no reference process")
+		}
+	}
+}
+
+// validateUUID checks that the given UUID is well-formed and that the file
+// name of the data set starts with it (the file name can contain a version
+// suffix, e.g. `<uuid>_01.00.000.xml`).
+func (v *validator) validateUUID(zipPath, uuid string, subject *Subject) {
+	if uuid == "" {
+		subject.Error("the data set has no UUID")
+		return
+	}
+	if !uuidPattern.MatchString(uuid) {
+		subject.Error("the UUID", uuid, "is not a valid UUID")
+		return
+	}
+	file := strings.ToLower(path.Base(zipPath))
+	if !strings.HasPrefix(file, strings.ToLower(uuid)) {
+		subject.Error("the file name", path.Base(zipPath),
+			"does not match the UUID", uuid)
+	}
+}
+
+func (v *validator) validateProcess(doc *etree.Document, subject *Subject) {
+	exchanges := ReadExchanges(doc)
+	refFlow := ReferenceFlowID(doc)
+	if refFlow == "" {
+		subject.Error("the process has no reference flow")
+	} else {
+		found := false
+		for _, e := range exchanges {
+			if e.InternalID == refFlow {
+				found = true
+				break
+			}
+		}
+		if !found {
+			subject.Error("the reference flow", refFlow, "of the process does",
+				"not exist").InternalID = refFlow
+		}
+	}
+
+	for _, e := range doc.FindElements("./processDataSet/exchanges/exchange") {
+		if readFlowAmount(e) == nil {
+			id := e.SelectAttrValue("dataSetInternalID", "")
+			subject.Error("the exchange", id, "has no flow reference").
+				InternalID = id
+		}
+	}
+
+	geography := doc.FindElement(
+		"./processDataSet/processInformation/geography/locationOfOperationSupplyOrProduction")
+	if geography != nil {
+		code := strings.TrimSpace(geography.SelectAttrValue("location", ""))
+		if code != "" && !Locations().Contains(code) {
+			subject.Warning("the location", code, "of the process is not a known",
+				"ILCD location code")
+		}
+	}
+	v.validateLocations(exchanges, "exchange", subject)
+
+	checked := make(map[string]bool)
+	for _, e := range exchanges {
+		if checked[e.FlowID] {
+			continue
+		}
+		checked[e.FlowID] = true
+		if v.flows.Get(e.FlowID) == nil {
+			f := subject.Warning("the flow", e.FlowID, "of exchange", e.InternalID,
+				"is not contained in the package")
+			f.InternalID = e.InternalID
+			f.Flow = e.FlowID
+		}
+	}
+}
+
+// validateLocations checks that the locations of the given exchanges or
+// factors are known ILCD location codes. Each unknown code is reported once.
+func (v *validator) validateLocations(amounts []*FlowAmount, what string,
+	subject *Subject) {
+	reported := make(map[string]bool)
+	for _, a := range amounts {
+		code := NormKey(a.Location)
+		if code == "" || reported[code] || Locations().Contains(code) {
+			continue
+		}
+		reported[code] = true
+		f := subject.Warning("the location", a.Location, "of", what, "with flow",
+			a.FlowID, "is not a known ILCD location code")
+		f.InternalID = a.InternalID
+		f.Flow = a.FlowID
+		f.Location = a.Location
+	}
+}
+
+func (v *validator) validateFlow(doc *etree.Document, subject *Subject) {
+	property := ReferenceFlowProperty(doc)
+	if property == "" {
+		subject.Error("the flow has no reference flow property")
+		return
+	}
+	if v.reader.FindDataSet(ilcd.FlowPropertyDataSet, property) == nil {
+		subject.Warning("the reference flow property", property,
+			"is not contained in the package")
+	}
+}
+
+func (v *validator) validateFlowProperty(doc *etree.Document, subject *Subject) {
+	ref := doc.FindElement(
+		"./flowPropertyDataSet/flowPropertiesInformation/quantitativeReference/referenceToReferenceUnitGroup")
+	unitGroup := ""
+	if ref != nil {
+		unitGroup = strings.TrimSpace(ref.SelectAttrValue("refObjectId", ""))
+	}
+	if unitGroup == "" {
+		subject.Error("the flow property has no reference unit group")
+		return
+	}
+	if v.reader.FindDataSet(ilcd.UnitGroupDataSet, unitGroup) == nil {
+		subject.Warning("the reference unit group", unitGroup,
+			"is not contained in the package")
+		return
+	}
+	if v.flows.refUnit(unitGroup) == "" {
+		subject.Error("the reference unit group", unitGroup,
+			"has no reference unit")
+	}
+}
+
+// validateSchema validates the given data set against the XML schemas.
+func (v *validator) validateSchema(data []byte, subject *Subject) {
+	violations, err := v.schemas.Validate(data)
+	if err == errNoSchema {
+		subject.Warning("could not validate the data set as there is no",
+			"schema for its root element")
+		return
+	}
+	if err != nil {
+		subject.Error("failed to validate the data set against the schema:", err)
+		return
+	}
+	for _, violation := range violations {
+		var f *Finding
+		if violation.Warning {
+			f = subject.Warning("schema:", violation.Message)
+		} else {
+			f = subject.Error("schema:", violation.Message)
+		}
+		f.Line = violation.Line
+		f.Column = violation.Column
+	}
+}
+
+// readPackageSchemas loads the XML schemas from the `ILCDFormat` folder of
+// the given package.
+func readPackageSchemas(reader *ilcd.ZipReader) (*xsdSet, error) {
+	files := make(map[string][]byte)
+	reader.EachFile(func(zipFile *ilcd.ZipFile) bool {
+		p := strings.ToLower(zipFile.Path())
+		if !strings.Contains(p, "ilcdformat/") || !strings.HasSuffix(p, ".xsd") {
+			return true
+		}
+		data, err := zipFile.Read()
+		if err != nil {
+			log.Println("ERROR: Failed to read schema", zipFile.Path(), err)
+			return true
+		}
+		files[zipFile.Path()] = data
+		return true
+	})
+	return loadSchemas(files)
+}
+
+// folderSchemas loads the XML schemas from the given folder and its
+// sub-folders.
+func folderSchemas(folder string) (*xsdSet, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(folder, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(strings.ToLower(p), ".xsd") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[p] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadSchemas(files)
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// flowRef is an exchange or characterization factor that is passed to the
+// mapping functions when the flow references of a data set are rewritten in
+// a stream. The mapping functions can set a new flow UUID, location, or flow
+// name which are then patched into the raw XML of the element.
+type flowRef struct {
+	FlowID   string
+	Location string
+
+	// the short description of the flow reference
+	Name string
+
+	newFlowID   string
+	newLocation *string
+	newName     *string
+}
+
+// SetFlow sets the new flow UUID of the reference.
+func (r *flowRef) SetFlow(uuid string) {
+	r.newFlowID = uuid
+}
+
+// SetLocation sets the new location of the exchange or factor.
+func (r *flowRef) SetLocation(location string) {
+	r.newLocation = &location
+}
+
+// SetName sets the new short description of the flow reference.
+func (r *flowRef) SetName(name string) {
+	r.newName = &name
+}
+
+func (r *flowRef) changed() bool {
+	return r.newFlowID != "" || r.newLocation != nil || r.newName != nil
+}
+
+// The element paths of exchanges and characterization factors.
+var (
+	exchangePath = []string{"processDataSet", "exchanges", "exchange"}
+	factorPath   = []string{
+		"LCIAMethodDataSet", "characterisationFactors", "factor"}
+)
+
+// The element paths of the UUID, process instances, and their connections in
+// life cycle models.
+var (
+	instancePath = []string{"lifeCycleModelDataSet", "lifeCycleModelInformation",
+		"technology", "processes", "processInstance"}
+	outputExchangePath = []string{"lifeCycleModelDataSet",
+		"lifeCycleModelInformation", "technology", "processes", "processInstance",
+		"connections", "outputExchange"}
+	downstreamProcessPath = []string{"lifeCycleModelDataSet",
+		"lifeCycleModelInformation", "technology", "processes", "processInstance",
+		"connections", "outputExchange", "downstreamProcess"}
+	processRefPath = []string{"lifeCycleModelDataSet",
+		"lifeCycleModelInformation", "technology", "processes", "processInstance",
+		"referenceToProcess"}
+	modelUUIDPath = []string{"lifeCycleModelDataSet",
+		"lifeCycleModelInformation", "dataSetInformation", "UUID"}
+)
+
+// rewriteFlowRefs calls the given function for each exchange or factor with
+// the given element path in the data set of the given reader and writes the
+// data set with the changes into the given writer; see `streamFlowRefs`. It
+// returns true if an element was changed and the number of checked elements.
+// Unchanged data sets are written byte by byte as they are.
+func rewriteFlowRefs(r io.Reader, w io.Writer, path []string,
+	fn func(ref *flowRef)) (bool, int, error) {
+	changed := false
+	count, err := streamFlowRefs(r, w, path, func(ref *flowRef) {
+		fn(ref)
+		if ref.changed() {
+			changed = true
+		}
+	})
+	return changed, count, err
+}
+
+// xmlTag is a start tag of an XML document with its position in the raw
+// bytes of the document. The attribute values of the tag can be changed and
+// then patched into the raw bytes with `patchTags`.
+type xmlTag struct {
+	xml.StartElement
+
+	// the original attributes of the tag
+	orig []xml.Attr
+
+	// the start and end position of the tag in the raw bytes
+	start, end int
+}
+
+// scanTags reads the tokens of the given XML data and calls the `onTag`
+// function for each start tag and the optional `onText` function for each
+// text with the path of the element.
+func scanTags(data []byte, onTag func(path []string, tag *xmlTag),
+	onText func(path []string, text string)) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var stack []string
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			tag := &xmlTag{
+				StartElement: t.Copy(),
+				orig:         t.Attr,
+				start:        int(start),
+				end:          int(dec.InputOffset())}
+			onTag(stack, tag)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if onText != nil {
+				onText(stack, string(t))
+			}
+		}
+	}
+}
+
+// patchTags patches the changed attribute values of the given tags into the
+// raw bytes of the given XML data; all other content, including the XML
+// declaration, namespace prefixes, the order of the attributes, and the
+// formatting, is kept. If no attribute value was changed, the given data are
+// returned as they are.
+func patchTags(data []byte, tags []*xmlTag) []byte {
+	var edits []rawEdit
+	for _, tag := range tags {
+		for i, attr := range tag.orig {
+			if i >= len(tag.Attr) || tag.Attr[i].Value == attr.Value {
+				continue
+			}
+			name := attr.Name.Local
+			if attr.Name.Space != "" {
+				name = attr.Name.Space + ":" + name
+			}
+			m := attrPattern(name).FindSubmatchIndex(data[tag.start:tag.end])
+			if m == nil {
+				continue
+			}
+			edits = append(edits, rawEdit{
+				start: tag.start + m[2] + 1,
+				end:   tag.start + m[3] - 1,
+				text:  escapeText(tag.Attr[i].Value)})
+		}
+	}
+	if len(edits) == 0 {
+		return data
+	}
+	return applyEdits(data, edits)
+}
+
+// findAttr returns the attribute with the given name and without namespace
+// prefix of the given tag or nil if there is no such attribute.
+func findAttr(tag *xml.StartElement, name string) *xml.Attr {
+	for i := range tag.Attr {
+		attr := &tag.Attr[i]
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return attr
+		}
+	}
+	return nil
+}
+
+// streamFlowRefs reads the XML from the given reader and writes it into the
+// given writer. For each element with the given path, the given function is
+// called and the changes of the flow reference are applied to the raw bytes
+// of that element. All other content is copied unchanged. Unlike a DOM, only
+// the current element is kept in memory so that also LCIA methods with tens
+// of thousands of factors can be processed with bounded memory. If the XML
+// cannot be parsed, the rest of the input is copied unchanged so that the
+// output is complete, and the parse error is returned.
+func streamFlowRefs(r io.Reader, w io.Writer, path []string,
+	fn func(ref *flowRef)) (int, error) {
+	cr := &captureReader{r: r}
+	dec := xml.NewDecoder(cr)
+	var stack []string
+	var elem *refElement
+	count := 0
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, werr := w.Write(cr.rest()); werr != nil {
+				return count, werr
+			}
+			if _, werr := io.Copy(w, r); werr != nil {
+				return count, werr
+			}
+			return count, err
+		}
+		end := dec.InputOffset()
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if elem == nil && pathEquals(stack, path) {
+				if _, err := w.Write(cr.take(start)); err != nil {
+					return count, err
+				}
+				elem = &refElement{offset: start, depth: len(stack),
+					prefix: t.Name.Space}
+				continue
+			}
+			if elem != nil {
+				elem.start(t, len(stack), start, end)
+			}
+		case xml.EndElement:
+			if elem != nil && len(stack) == elem.depth {
+				count++
+				raw := cr.take(end)
+				if _, err := w.Write(elem.apply(raw, fn)); err != nil {
+					return count, err
+				}
+				elem = nil
+			} else if elem != nil {
+				elem.end(t, len(stack), start, end)
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if elem != nil {
+				elem.text(t)
+			}
+		}
+	}
+	if _, err := w.Write(cr.rest()); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func pathEquals(stack, path []string) bool {
+	if len(stack) != len(path) {
+		return false
+	}
+	for i := range stack {
+		if stack[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// captureReader keeps the bytes that were read by the XML decoder but not
+// yet written to the output.
+type captureReader struct {
+	r io.Reader
+
+	// the bytes that were read but not taken yet
+	buf []byte
+
+	// the input offset of the first byte in buf
+	base int64
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf = append(c.buf, p[:n]...)
+	return n, err
+}
+
+// take returns the bytes up to the given input offset and removes them from
+// the buffer.
+func (c *captureReader) take(offset int64) []byte {
+	n := int(offset - c.base)
+	taken := make([]byte, n)
+	copy(taken, c.buf[:n])
+	c.buf = append(c.buf[:0], c.buf[n:]...)
+	c.base = offset
+	return taken
+}
+
+// rest returns all remaining bytes of the buffer.
+func (c *captureReader) rest() []byte {
+	rest := c.buf
+	c.buf = nil
+	return rest
+}
+
+// refElement collects the positions of the parts of an exchange or factor
+// that can be changed. The positions are input offsets.
+type refElement struct {
+	offset int64
+	depth  int
+
+	// the namespace prefix of the element which is used for an inserted
+	// location element
+	prefix string
+
+	ref flowRef
+
+	// the start tag of the flow reference
+	refTag [2]int64
+	inRef  bool
+
+	// the start tag, text, and end of the location element
+	hasLocation bool
+	locTag      [2]int64
+	locText     [2]int64
+	locClosed   bool
+	inLocation  bool
+
+	// the text of the short description
+	hasName bool
+	nameTag [2]int64
+	nameEnd int64
+	inName  bool
+
+	// the position of the `exchangeDirection` element and the whitespace
+	// before it
+	dirStart  int64
+	dirIndent string
+	lastSpace string
+}
+
+func (e *refElement) start(t xml.StartElement, depth int, start, end int64) {
+	space := e.lastSpace
+	e.lastSpace = ""
+	child := depth == e.depth+1
+	switch {
+	case child && t.Name.Local == "referenceToFlowDataSet":
+		e.refTag = [2]int64{start, end}
+		e.inRef = true
+		for _, attr := range t.Attr {
+			if attr.Name.Local == "refObjectId" {
+				e.ref.FlowID = strings.TrimSpace(attr.Value)
+			}
+		}
+	case child && t.Name.Local == "location":
+		e.prefix = t.Name.Space
+		e.hasLocation = true
+		e.inLocation = true
+		e.locTag = [2]int64{start, end}
+		e.locText = [2]int64{end, end}
+	case child && t.Name.Local == "exchangeDirection":
+		if e.dirStart == 0 {
+			e.dirStart = start
+			e.dirIndent = space
+		}
+	case e.inRef && depth == e.depth+2 && t.Name.Local == "shortDescription":
+		if !e.hasName {
+			e.hasName = true
+			e.inName = true
+			e.nameTag = [2]int64{start, end}
+		}
+	}
+}
+
+func (e *refElement) end(t xml.EndElement, depth int, start, end int64) {
+	e.lastSpace = ""
+	switch {
+	case e.inLocation && depth == e.depth+1:
+		e.inLocation = false
+		e.locClosed = start != end
+		e.locText[1] = start
+		e.ref.Location = strings.TrimSpace(e.ref.Location)
+	case e.inName && depth == e.depth+2:
+		e.inName = false
+		e.nameEnd = start
+		if start == end {
+			// an empty element: <shortDescription/>
+			e.hasName = false
+		}
+	case e.inRef && depth == e.depth+1:
+		e.inRef = false
+	}
+}
+
+func (e *refElement) text(t xml.CharData) {
+	switch {
+	case e.inLocation:
+		e.ref.Location += string(t)
+	case e.inName:
+		e.ref.Name += string(t)
+	default:
+		if strings.TrimSpace(string(t)) == "" {
+			e.lastSpace = string(t)
+		}
+	}
+}
+
+// rawEdit replaces the bytes between the given positions.
+type rawEdit struct {
+	start, end int
+	text       string
+}
+
+// the compiled patterns of the attributes that are patched in the flow
+// mappings; see `attrPattern`
+var attrPatterns = map[string]*regexp.Regexp{
+	"refObjectId": compileAttrPattern("refObjectId"),
+	"uri":         compileAttrPattern("uri"),
+	"flowUUID":    compileAttrPattern("flowUUID"),
+}
+
+// attrPattern returns a pattern that matches the attribute with the given
+// name in a start tag; the first group is the quoted value. The patterns of
+// the attributes that are patched in the flow mappings are compiled once.
+func attrPattern(name string) *regexp.Regexp {
+	if p := attrPatterns[name]; p != nil {
+		return p
+	}
+	return compileAttrPattern(name)
+}
+
+func compileAttrPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`\s` + regexp.QuoteMeta(name) +
+		`\s*=\s*("[^"]*"|'[^']*')`)
+}
+
+// apply calls the given function with the flow reference of the element and
+// patches the changes into the raw bytes of the element.
+func (e *refElement) apply(raw []byte, fn func(ref *flowRef)) []byte {
+	fn(&e.ref)
+	if !e.ref.changed() {
+		return raw
+	}
+	pos := func(offset int64) int {
+		return int(offset - e.offset)
+	}
+	var edits []rawEdit
+
+	if e.ref.newFlowID != "" && e.refTag[1] > 0 {
+		tagStart := pos(e.refTag[0])
+		tag := raw[tagStart:pos(e.refTag[1])]
+		for _, attr := range []struct {
+			pattern *regexp.Regexp
+			value   string
+		}{
+			{attrPattern("refObjectId"), e.ref.newFlowID},
+			{attrPattern("uri"), "../flows/" + e.ref.newFlowID + ".xml"},
+		} {
+			if m := attr.pattern.FindSubmatchIndex(tag); m != nil {
+				edits = append(edits, rawEdit{
+					start: tagStart + m[2] + 1,
+					end:   tagStart + m[3] - 1,
+					text:  escapeText(attr.value)})
+			}
+		}
+	}
+
+	if e.ref.newLocation != nil {
+		loc := escapeText(*e.ref.newLocation)
+		name := "location"
+		if e.prefix != "" {
+			name = e.prefix + ":location"
+		}
+		element := "<" + name + ">" + loc + "</" + name + ">"
+		switch {
+		case e.hasLocation && e.locClosed:
+			edits = append(edits, rawEdit{
+				start: pos(e.locText[0]), end: pos(e.locText[1]), text: loc})
+		case e.hasLocation:
+			// an empty element: <location/>
+			edits = append(edits, rawEdit{
+				start: pos(e.locTag[0]), end: pos(e.locTag[1]), text: element})
+		case loc != "" && e.dirStart > 0:
+			at := pos(e.dirStart)
+			edits = append(edits, rawEdit{start: at, end: at,
+				text: element + e.dirIndent})
+		case loc != "":
+			// insert the location before the end tag of the element
+			at := bytes.LastIndex(raw, []byte("</"))
+			if at >= 0 {
+				edits = append(edits, rawEdit{start: at, end: at, text: element})
+			}
+		}
+	}
+
+	if e.ref.newName != nil && e.hasName {
+		edits = append(edits, rawEdit{
+			start: pos(e.nameTag[1]), end: pos(e.nameEnd),
+			text: escapeText(*e.ref.newName)})
+	}
+
+	return applyEdits(raw, edits)
+}
+
+// applyEdits replaces the ranges of the given edits in the raw bytes.
+func applyEdits(raw []byte, edits []rawEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	var buf bytes.Buffer
+	buf.Grow(len(raw))
+	last := 0
+	for _, edit := range edits {
+		buf.Write(raw[last:edit.start])
+		buf.WriteString(edit.text)
+		last = edit.end
+	}
+	buf.Write(raw[last:])
+	return buf.Bytes()
+}
+
+func escapeText(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStreamFlowRefs(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		fn   func(ref *flowRef)
+		want string
+
+		// the values that are read from the exchange
+		flow     string
+		location string
+		desc     string
+	}{
+		{
+			name: "flow reference",
+			xml: `<exchange><referenceToFlowDataSet type="flow data set" refObjectId="a" uri="../flows/a.xml"/>` +
+				`<exchangeDirection>Output</exchangeDirection></exchange>`,
+			fn: func(ref *flowRef) { ref.SetFlow("b") },
+			want: `<exchange><referenceToFlowDataSet type="flow data set" refObjectId="b" uri="../flows/b.xml"/>` +
+				`<exchangeDirection>Output</exchangeDirection></exchange>`,
+			flow: "a",
+		},
+		{
+			name: "single-quoted attributes",
+			xml: `<exchange><referenceToFlowDataSet refObjectId = 'a' version='1' uri='../flows/a.xml'/>` +
+				`</exchange>`,
+			fn: func(ref *flowRef) { ref.SetFlow("b") },
+			want: `<exchange><referenceToFlowDataSet refObjectId = 'b' version='1' uri='../flows/b.xml'/>` +
+				`</exchange>`,
+			flow: "a",
+		},
+		{
+			name: "prefixed elements",
+			xml: `<p:exchange><p:referenceToFlowDataSet refObjectId="a"><c:shortDescription>CO2</c:shortDescription>` +
+				"</p:referenceToFlowDataSet>\n  <p:exchangeDirection>Output</p:exchangeDirection></p:exchange>",
+			fn: func(ref *flowRef) { ref.SetLocation("DE") },
+			want: `<p:exchange><p:referenceToFlowDataSet refObjectId="a"><c:shortDescription>CO2</c:shortDescription>` +
+				"</p:referenceToFlowDataSet>\n  <p:location>DE</p:location>\n  <p:exchangeDirection>Output</p:exchangeDirection></p:exchange>",
+			flow: "a",
+			desc: "CO2",
+		},
+		{
+			name:     "prefixed location",
+			xml:      `<p:exchange><p:referenceToFlowDataSet refObjectId="a"/><p:location>PL</p:location></p:exchange>`,
+			fn:       func(ref *flowRef) { ref.SetLocation("DE") },
+			want:     `<p:exchange><p:referenceToFlowDataSet refObjectId="a"/><p:location>DE</p:location></p:exchange>`,
+			flow:     "a",
+			location: "PL",
+		},
+		{
+			name: "empty location element",
+			xml:  `<exchange><referenceToFlowDataSet refObjectId="a"/><location/></exchange>`,
+			fn:   func(ref *flowRef) { ref.SetLocation("PL") },
+			want: `<exchange><referenceToFlowDataSet refObjectId="a"/><location>PL</location></exchange>`,
+			flow: "a",
+		},
+		{
+			name: "missing location",
+			xml:  `<exchange><referenceToFlowDataSet refObjectId="a"/><meanAmount>1</meanAmount></exchange>`,
+			fn:   func(ref *flowRef) { ref.SetLocation("PL") },
+			want: `<exchange><referenceToFlowDataSet refObjectId="a"/><meanAmount>1</meanAmount><location>PL</location></exchange>`,
+			flow: "a",
+		},
+		{
+			name:     "location with whitespace",
+			xml:      `<exchange><referenceToFlowDataSet refObjectId=" a "/><location> PL </location></exchange>`,
+			fn:       func(ref *flowRef) {},
+			want:     `<exchange><referenceToFlowDataSet refObjectId=" a "/><location> PL </location></exchange>`,
+			flow:     "a",
+			location: "PL",
+		},
+		{
+			name: "entities in short description",
+			xml: `<exchange><referenceToFlowDataSet refObjectId="a"><common:shortDescription xml:lang="en">` +
+				`H&amp;M &lt;emission&gt; - PL</common:shortDescription></referenceToFlowDataSet></exchange>`,
+			fn: func(ref *flowRef) { ref.SetName("H&M <emission>") },
+			want: `<exchange><referenceToFlowDataSet refObjectId="a"><common:shortDescription xml:lang="en">` +
+				`H&amp;M &lt;emission&gt;</common:shortDescription></referenceToFlowDataSet></exchange>`,
+			flow: "a",
+			desc: "H&M <emission> - PL",
+		},
+		{
+			name: "entities in attributes",
+			xml:  `<exchange><referenceToFlowDataSet refObjectId="a" uri="x?a=1&amp;b=2"/></exchange>`,
+			fn:   func(ref *flowRef) { ref.SetFlow("b&c") },
+			want: `<exchange><referenceToFlowDataSet refObjectId="b&amp;c" uri="../flows/b&amp;c.xml"/></exchange>`,
+			flow: "a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := "<?xml version=\"1.0\"?>\n<processDataSet><exchanges>" +
+				test.xml + "</exchanges></processDataSet>"
+			var read flowRef
+			var buf bytes.Buffer
+			count, err := streamFlowRefs(bytes.NewReader([]byte(data)), &buf,
+				exchangePath, func(ref *flowRef) {
+					read = *ref
+					test.fn(ref)
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Errorf("checked %d exchanges, want 1", count)
+			}
+			if read.FlowID != test.flow || read.Location != test.location ||
+				read.Name != test.desc {
+				t.Errorf("read flow=%q location=%q name=%q, want %q %q %q",
+					read.FlowID, read.Location, read.Name,
+					test.flow, test.location, test.desc)
+			}
+			want := "<?xml version=\"1.0\"?>\n<processDataSet><exchanges>" +
+				test.want + "</exchanges></processDataSet>"
+			if got := buf.String(); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestStreamFlowRefsInvalidXML(t *testing.T) {
+	// the rest of the input is copied unchanged when the XML is not valid
+	data := `<processDataSet><exchanges><exchange><referenceToFlowDataSet refObjectId="a"/>` +
+		`</exchange><exchange><meanAmount>&x;</meanAmount></exchange></exchanges></processDataSet>`
+	var buf bytes.Buffer
+	_, err := streamFlowRefs(bytes.NewReader([]byte(data)), &buf, exchangePath,
+		func(ref *flowRef) { ref.SetFlow("b") })
+	if err == nil {
+		t.Error("expected a parse error")
+	}
+	want := `<processDataSet><exchanges><exchange><referenceToFlowDataSet refObjectId="b"/>` +
+		`</exchange><exchange><meanAmount>&x;</meanAmount></exchange></exchanges></processDataSet>`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// The functions in this file modify etree documents while keeping the
+// formatting of the unchanged parts: new elements are indented like their
+// siblings and removed elements take their indentation with them. This is
+// used instead of re-indenting the whole document so that a diff of a
+// changed data set only shows the changed elements.
+
+// appendIndented appends the given element as last child element to the
+// given parent and indents it like the existing child elements of the parent.
+// If the parent is not indented, the element is appended as is.
+func appendIndented(parent, child *etree.Element) {
+	outer := precedingSpace(parent)
+	inner := ""
+	for _, e := range parent.ChildElements() {
+		inner = precedingSpace(e)
+		break
+	}
+	if inner == "" && len(parent.ChildElements()) == 0 && outer != "" {
+		inner = outer + "  "
+	}
+	if inner == "" {
+		parent.AddChild(child)
+		return
+	}
+	unit := "  "
+	if strings.HasPrefix(inner, outer) && len(inner) > len(outer) {
+		unit = inner[len(outer):]
+	}
+	indentChildren(child, inner, unit)
+
+	// insert the element before the whitespace of the closing tag
+	n := len(parent.Child)
+	if n > 0 && isSpaceData(parent.Child[n-1]) {
+		parent.InsertChildAt(n-1, etree.NewCharData(inner))
+		parent.InsertChildAt(n, child)
+		return
+	}
+	parent.AddChild(etree.NewCharData(inner))
+	parent.AddChild(child)
+	parent.AddChild(etree.NewCharData(outer))
+}
+
+// removeIndented removes the given child element from its parent together
+// with the whitespace in front of it.
+func removeIndented(parent, child *etree.Element) {
+	i := child.Index()
+	if i > 0 && isSpaceData(parent.Child[i-1]) {
+		parent.RemoveChildAt(i - 1)
+	}
+	parent.RemoveChild(child)
+}
+
+// indentChildren indents the child elements of the given element which is
+// located at the given indentation.
+func indentChildren(e *etree.Element, indent, unit string) {
+	children := e.ChildElements()
+	if len(children) == 0 {
+		return
+	}
+	for _, child := range children {
+		e.InsertChildAt(child.Index(), etree.NewCharData(indent+unit))
+		indentChildren(child, indent+unit, unit)
+	}
+	e.AddChild(etree.NewCharData(indent))
+}
+
+// precedingSpace returns the whitespace in front of the given element if it
+// starts with a line break; otherwise, an empty string is returned.
+func precedingSpace(e *etree.Element) string {
+	parent := e.Parent()
+	i := e.Index()
+	if parent == nil || i <= 0 || !isSpaceData(parent.Child[i-1]) {
+		return ""
+	}
+	space := parent.Child[i-1].(*etree.CharData).Data
+	if j := strings.LastIndex(space, "\n"); j >= 0 {
+		return space[j:]
+	}
+	return ""
+}
+
+func isSpaceData(t etree.Token) bool {
+	data, ok := t.(*etree.CharData)
+	return ok && strings.TrimSpace(data.Data) == ""
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+func TestAppendIndented(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want string
+	}{
+		{
+			name: "indented",
+			xml:  "<a>\n  <b>\n    <c/>\n  </b>\n</a>",
+			want: "<a>\n  <b>\n    <c/>\n    <d>\n      <e>x</e>\n    </d>\n  </b>\n</a>",
+		},
+		{
+			name: "empty parent",
+			xml:  "<a>\n  <b/>\n</a>",
+			want: "<a>\n  <b>\n    <d>\n      <e>x</e>\n    </d>\n  </b>\n</a>",
+		},
+		{
+			name: "tabs",
+			xml:  "<a>\n\t<b>\n\t\t<c/>\n\t</b>\n</a>",
+			want: "<a>\n\t<b>\n\t\t<c/>\n\t\t<d>\n\t\t\t<e>x</e>\n\t\t</d>\n\t</b>\n</a>",
+		},
+		{
+			name: "not indented",
+			xml:  "<a><b><c/></b></a>",
+			want: "<a><b><c/><d><e>x</e></d></b></a>",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := etree.NewDocument()
+			if err := doc.ReadFromString(test.xml); err != nil {
+				t.Fatal(err)
+			}
+			d := etree.NewElement("d")
+			d.CreateElement("e").SetText("x")
+			appendIndented(doc.FindElement("/a/b"), d)
+			if got, _ := doc.WriteToString(); got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRemoveIndented(t *testing.T) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString("<a>\n  <b/>\n  <c/>\n</a>"); err != nil {
+		t.Fatal(err)
+	}
+	removeIndented(doc.Root(), doc.FindElement("/a/c"))
+	want := "<a>\n  <b/>\n</a>"
+	if got, _ := doc.WriteToString(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
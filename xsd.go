@@ -0,0 +1,1300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/beevik/etree"
+)
+
+// The XSD validator in this file supports the subset of XML Schema that is
+// used by the ILCD format schemas: global and local element declarations,
+// named and anonymous complex and simple types, sequences, choices, `all`
+// groups, model and attribute groups, wildcards, simple and complex content
+// extensions and restrictions, and the common facets of simple types.
+// Identity constraints and substitution groups are not checked. Content
+// models are matched greedily without backtracking; this is correct for
+// schemas that satisfy the Unique Particle Attribution constraint of XML
+// Schema (as the ILCD schemas do) where the next element always determines
+// the matching particle. Patterns that cannot be translated into Go regular
+// expressions are reported as warnings and not checked.
+
+const (
+	xsdNamespace = "http://www.w3.org/2001/XMLSchema"
+	xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+	xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+	// the maximum number of schema violations that are reported per document
+	xsdMaxErrors = 100
+)
+
+// errNoSchema is returned when there is no global element declaration for the
+// root element of a document.
+var errNoSchema = errors.New("no schema found for the root element")
+
+// xsdSchema contains the properties of a schema document that are needed to
+// interpret its components.
+type xsdSchema struct {
+	targetNS            string
+	qualifiedElements   bool
+	qualifiedAttributes bool
+}
+
+// xsdNode is an element of a schema document together with the schema in
+// which it is defined.
+type xsdNode struct {
+	elem   *etree.Element
+	schema *xsdSchema
+}
+
+// xsdType is a resolved type: either a built-in type of XML Schema or a
+// complex or simple type definition.
+type xsdType struct {
+	builtin string
+	node    *xsdNode
+}
+
+// xsdSet is a set of schemas with their global components indexed by their
+// qualified names.
+type xsdSet struct {
+	elements   map[xml.Name]*xsdNode
+	types      map[xml.Name]*xsdNode
+	groups     map[xml.Name]*xsdNode
+	attrGroups map[xml.Name]*xsdNode
+	attributes map[xml.Name]*xsdNode
+
+	models   map[*etree.Element]*xsdModel
+	patterns map[string]*regexp.Regexp
+}
+
+// xsdError is a schema violation at a position of a document. Warnings are
+// problems of the validation itself, e.g. unsupported patterns, and not
+// violations of the schema.
+type xsdError struct {
+	Line    int
+	Column  int
+	Message string
+	Warning bool
+}
+
+// loadSchemas reads the given schema files (path -> content) into a schema
+// set. Schemas without target namespace that are included by other schemas
+// get the target namespace of the including schema.
+func loadSchemas(files map[string][]byte) (*xsdSet, error) {
+	set := &xsdSet{
+		elements:   make(map[xml.Name]*xsdNode),
+		types:      make(map[xml.Name]*xsdNode),
+		groups:     make(map[xml.Name]*xsdNode),
+		attrGroups: make(map[xml.Name]*xsdNode),
+		attributes: make(map[xml.Name]*xsdNode),
+		models:     make(map[*etree.Element]*xsdModel),
+		patterns:   make(map[string]*regexp.Regexp)}
+
+	// file name -> schema root
+	roots := make(map[string]*etree.Element)
+	var names []string
+	for p, data := range files {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(data); err != nil {
+			return nil, errors.New("failed to parse schema " + p + ": " + err.Error())
+		}
+		root := doc.Root()
+		if root == nil || root.Tag != "schema" {
+			continue
+		}
+		name := strings.ToLower(path.Base(p))
+		roots[name] = root
+		names = append(names, name)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no schema files found")
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		root := roots[name]
+		ns := root.SelectAttrValue("targetNamespace", "")
+		set.register(root, ns)
+		if ns == "" {
+			continue
+		}
+		for _, include := range root.SelectElements("include") {
+			location := strings.ToLower(path.Base(include.SelectAttrValue("schemaLocation", "")))
+			if included := roots[location]; included != nil &&
+				included.SelectAttrValue("targetNamespace", "") == "" {
+				set.register(included, ns)
+			}
+		}
+	}
+	return set, nil
+}
+
+// register adds the global components of the given schema root to the set
+// using the given namespace.
+func (set *xsdSet) register(root *etree.Element, ns string) {
+	schema := &xsdSchema{
+		targetNS:            ns,
+		qualifiedElements:   root.SelectAttrValue("elementFormDefault", "") == "qualified",
+		qualifiedAttributes: root.SelectAttrValue("attributeFormDefault", "") == "qualified"}
+	for _, child := range root.ChildElements() {
+		name := child.SelectAttrValue("name", "")
+		if name == "" {
+			continue
+		}
+		node := &xsdNode{elem: child, schema: schema}
+		qname := xml.Name{Space: ns, Local: name}
+		switch child.Tag {
+		case "element":
+			set.elements[qname] = node
+		case "complexType", "simpleType":
+			set.types[qname] = node
+		case "group":
+			set.groups[qname] = node
+		case "attributeGroup":
+			set.attrGroups[qname] = node
+		case "attribute":
+			set.attributes[qname] = node
+		}
+	}
+}
+
+// children returns the schema elements below the given node; annotations
+// are skipped.
+func (n *xsdNode) children() []*xsdNode {
+	var nodes []*xsdNode
+	for _, child := range n.elem.ChildElements() {
+		if child.Tag == "annotation" {
+			continue
+		}
+		nodes = append(nodes, &xsdNode{elem: child, schema: n.schema})
+	}
+	return nodes
+}
+
+func (n *xsdNode) child(tag string) *xsdNode {
+	for _, child := range n.children() {
+		if child.elem.Tag == tag {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *xsdNode) attr(key string) string {
+	return strings.TrimSpace(n.elem.SelectAttrValue(key, ""))
+}
+
+// qname resolves the given QName value in the context of the node.
+func (n *xsdNode) qname(value string) xml.Name {
+	prefix, local := "", value
+	if i := strings.Index(value, ":"); i >= 0 {
+		prefix, local = value[:i], value[i+1:]
+	}
+	if prefix == "xml" {
+		return xml.Name{Space: xmlNamespace, Local: local}
+	}
+	for e := n.elem; e != nil; e = e.Parent() {
+		for _, a := range e.Attr {
+			if (prefix == "" && a.Space == "" && a.Key == "xmlns") ||
+				(prefix != "" && a.Space == "xmlns" && a.Key == prefix) {
+				return xml.Name{Space: a.Value, Local: local}
+			}
+		}
+	}
+	return xml.Name{Space: n.schema.targetNS, Local: local}
+}
+
+// occurs returns the minimum and maximum occurrences of the given particle;
+// the maximum is -1 if it is unbounded.
+func (n *xsdNode) occurs() (int, int) {
+	min, max := 1, 1
+	if v := n.attr("minOccurs"); v != "" {
+		min, _ = strconv.Atoi(v)
+	}
+	if v := n.attr("maxOccurs"); v == "unbounded" {
+		max = -1
+	} else if v != "" {
+		max, _ = strconv.Atoi(v)
+	}
+	return min, max
+}
+
+// resolveType returns the type with the given name or nil if it is unknown.
+func (set *xsdSet) resolveType(name xml.Name) *xsdType {
+	if name.Space == xsdNamespace {
+		return &xsdType{builtin: name.Local}
+	}
+	if node := set.types[name]; node != nil {
+		return &xsdType{node: node}
+	}
+	return nil
+}
+
+// typeOf returns the type of the given element or attribute declaration:
+// the referenced type, an anonymous type, or `anyType` (`anySimpleType` for
+// attributes).
+func (set *xsdSet) typeOf(decl *xsdNode) *xsdType {
+	if t := decl.attr("type"); t != "" {
+		return set.resolveType(decl.qname(t))
+	}
+	for _, child := range decl.children() {
+		if child.elem.Tag == "complexType" || child.elem.Tag == "simpleType" {
+			return &xsdType{node: child}
+		}
+	}
+	if decl.elem.Tag == "attribute" {
+		return &xsdType{builtin: "anySimpleType"}
+	}
+	return &xsdType{builtin: "anyType"}
+}
+
+// xsdModel is the flattened model of a complex type.
+type xsdModel struct {
+	attributes []*xsdNode
+
+	// the attribute wildcards of the type and its base types
+	anyAttributes []*xsdNode
+
+	// the content particles which are matched as a sequence
+	particles []*xsdNode
+
+	// the type of the text content for types with simple content
+	simple *xsdType
+
+	mixed bool
+	any   bool
+}
+
+// model returns the (cached) model of the given complex type.
+func (set *xsdSet) model(ct *xsdNode) *xsdModel {
+	if m, ok := set.models[ct.elem]; ok {
+		return m
+	}
+	m := &xsdModel{mixed: ct.attr("mixed") == "true"}
+	set.models[ct.elem] = m // prevents endless recursion
+	for _, child := range ct.children() {
+		switch child.elem.Tag {
+		case "sequence", "choice", "all", "group":
+			m.particles = append(m.particles, child)
+		case "attribute", "attributeGroup", "anyAttribute":
+			set.addAttributes(m, child)
+		case "complexContent":
+			if child.attr("mixed") == "true" {
+				m.mixed = true
+			}
+			set.derive(m, child, false)
+		case "simpleContent":
+			set.derive(m, child, true)
+		}
+	}
+	return m
+}
+
+// derive adds the content and attributes of a complex or simple content
+// derivation to the given model.
+func (set *xsdSet) derive(m *xsdModel, content *xsdNode, simple bool) {
+	for _, d := range content.children() {
+		if d.elem.Tag != "extension" && d.elem.Tag != "restriction" {
+			continue
+		}
+		base := set.resolveType(d.qname(d.attr("base")))
+		var baseModel *xsdModel
+		if base != nil && base.node != nil && base.node.elem.Tag == "complexType" {
+			baseModel = set.model(base.node)
+			m.attributes = append(m.attributes, baseModel.attributes...)
+			m.anyAttributes = append(m.anyAttributes, baseModel.anyAttributes...)
+		}
+		if simple {
+			if baseModel != nil {
+				m.simple = baseModel.simple
+			} else {
+				m.simple = base
+			}
+		} else if d.elem.Tag == "extension" {
+			if baseModel != nil {
+				m.particles = append(m.particles, baseModel.particles...)
+				m.mixed = m.mixed || baseModel.mixed
+			} else if base != nil && base.builtin == "anyType" {
+				m.any = true
+			}
+		}
+		for _, child := range d.children() {
+			switch child.elem.Tag {
+			case "sequence", "choice", "all", "group":
+				if !simple {
+					m.particles = append(m.particles, child)
+				}
+			case "attribute", "attributeGroup", "anyAttribute":
+				set.addAttributes(m, child)
+			}
+		}
+	}
+}
+
+// allowsAttribute returns true if an undeclared attribute with the given name
+// is matched by an attribute wildcard of the model.
+func (m *xsdModel) allowsAttribute(name xml.Name) bool {
+	if name.Space == xmlNamespace {
+		return true
+	}
+	for _, any := range m.anyAttributes {
+		if matchesWildcard(any, name.Space) {
+			return true
+		}
+	}
+	return false
+}
+
+// addAttributes adds the given attribute declaration, attribute group, or
+// attribute wildcard to the model.
+func (set *xsdSet) addAttributes(m *xsdModel, node *xsdNode) {
+	switch node.elem.Tag {
+	case "anyAttribute":
+		m.anyAttributes = append(m.anyAttributes, node)
+	case "attribute":
+		m.attributes = append(m.attributes, node)
+	case "attributeGroup":
+		group := node
+		if ref := node.attr("ref"); ref != "" {
+			group = set.attrGroups[node.qname(ref)]
+			if group == nil {
+				return
+			}
+		}
+		for _, child := range group.children() {
+			set.addAttributes(m, child)
+		}
+	}
+}
+
+// xmlNode is an element of an instance document with its position.
+type xmlNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+	line     int
+	column   int
+}
+
+// parseXMLNodes parses the given document into a tree of nodes with their
+// line and column numbers.
+func parseXMLNodes(data []byte) (*xmlNode, error) {
+	var lines []int // offsets of the line starts
+	lines = append(lines, 0)
+	for i, b := range data {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	position := func(offset int) (int, int) {
+		i := sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+		return i + 1, utf8.RuneCount(data[lines[i]:offset]) + 1
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+	var root *xmlNode
+	var stack []*xmlNode
+	var texts []*strings.Builder
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name, attrs: t.Copy().Attr}
+			node.line, node.column = position(offset)
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+			texts = append(texts, &strings.Builder{})
+		case xml.CharData:
+			if len(texts) > 0 {
+				texts[len(texts)-1].Write(t)
+			}
+		case xml.EndElement:
+			stack[len(stack)-1].text = texts[len(texts)-1].String()
+			stack = stack[:len(stack)-1]
+			texts = texts[:len(texts)-1]
+		}
+	}
+	if root == nil {
+		return nil, errors.New("the document has no root element")
+	}
+	return root, nil
+}
+
+// Validate validates the given document against the schemas of the set. It
+// returns `errNoSchema` if the root element is not declared in the schemas.
+func (set *xsdSet) Validate(data []byte) ([]*xsdError, error) {
+	root, err := parseXMLNodes(data)
+	if err != nil {
+		return nil, err
+	}
+	decl := set.elements[root.name]
+	if decl == nil {
+		return nil, errNoSchema
+	}
+	v := &xsdValidator{set: set, warned: make(map[string]bool)}
+	v.validateElement(root, decl)
+	return v.errors, nil
+}
+
+// xsdValidator validates the elements of a document.
+type xsdValidator struct {
+	set    *xsdSet
+	errors []*xsdError
+
+	// the position of the farthest child that could not be matched in the
+	// current content model and the names of the elements that were expected
+	// at that position
+	farthest int
+	expected []string
+
+	// the patterns that could not be checked for the current value and the
+	// patterns for which a warning was already reported
+	unsupported []string
+	warned      map[string]bool
+}
+
+// anyDecl marks children that were matched by a wildcard; these are not
+// validated.
+var anyDecl = &xsdNode{}
+
+func (v *xsdValidator) fail(n *xmlNode, msg ...string) {
+	if len(v.errors) >= xsdMaxErrors {
+		return
+	}
+	v.errors = append(v.errors, &xsdError{
+		Line:    n.line,
+		Column:  n.column,
+		Message: strings.Join(msg, " ")})
+}
+
+// warnUnsupported adds a warning for each pattern that could not be checked
+// for the last value of the given node. Each pattern is reported only once.
+func (v *xsdValidator) warnUnsupported(n *xmlNode) {
+	for _, p := range v.unsupported {
+		if v.warned[p] || len(v.errors) >= xsdMaxErrors {
+			continue
+		}
+		v.warned[p] = true
+		v.errors = append(v.errors, &xsdError{
+			Line:   n.line,
+			Column: n.column,
+			Message: "unsupported pattern " + p + " in element " + n.name.Local +
+				"; values are not checked against it",
+			Warning: true})
+	}
+	v.unsupported = nil
+}
+
+func (v *xsdValidator) validateElement(n *xmlNode, decl *xsdNode) {
+	if ref := decl.attr("ref"); ref != "" {
+		decl = v.set.elements[decl.qname(ref)]
+		if decl == nil {
+			return
+		}
+	}
+	t := v.set.typeOf(decl)
+	if t == nil {
+		v.fail(n, "the type", decl.attr("type"), "of element", n.name.Local,
+			"is not defined in the schemas")
+		return
+	}
+	if t.builtin == "anyType" {
+		return
+	}
+	if t.builtin != "" || t.node.elem.Tag == "simpleType" {
+		v.checkAttributes(n, &xsdModel{})
+		if len(n.children) > 0 {
+			v.fail(n.children[0], "the element", n.name.Local,
+				"must not contain child elements")
+			return
+		}
+		if msg := v.checkSimple(n.text, t); msg != "" {
+			v.fail(n, "invalid value of element", n.name.Local+":", msg)
+		}
+		v.warnUnsupported(n)
+		return
+	}
+
+	m := v.set.model(t.node)
+	if m.any {
+		return
+	}
+	v.checkAttributes(n, m)
+	if m.simple != nil {
+		if len(n.children) > 0 {
+			v.fail(n.children[0], "the element", n.name.Local,
+				"must not contain child elements")
+			return
+		}
+		if msg := v.checkSimple(n.text, m.simple); msg != "" {
+			v.fail(n, "invalid value of element", n.name.Local+":", msg)
+		}
+		v.warnUnsupported(n)
+		return
+	}
+	if !m.mixed && strings.TrimSpace(n.text) != "" {
+		v.fail(n, "the element", n.name.Local, "must not contain text")
+	}
+
+	decls := make([]*xsdNode, len(n.children))
+	v.farthest, v.expected = 0, nil
+	pos, ok := 0, true
+	for _, p := range m.particles {
+		if pos, ok = v.matchOccurs(p, n.children, pos, decls); !ok {
+			break
+		}
+	}
+	valid := pos
+	if !ok || pos < len(n.children) {
+		at := pos
+		if !ok && v.farthest > at {
+			at = v.farthest
+		}
+		expected := ""
+		if len(v.expected) > 0 && v.farthest == at {
+			expected = "; expected: " + strings.Join(v.expected, ", ")
+		}
+		if at < len(n.children) {
+			v.fail(n.children[at], "unexpected element", n.children[at].name.Local,
+				"in", n.name.Local+expected)
+		} else {
+			v.fail(n, "the content of element", n.name.Local,
+				"is incomplete"+expected)
+		}
+		valid = at
+	}
+	for i := 0; i < valid && i < len(n.children); i++ {
+		if decls[i] != nil && decls[i] != anyDecl {
+			v.validateElement(n.children[i], decls[i])
+		}
+	}
+}
+
+// checkAttributes checks the attributes of the given element against the
+// attribute declarations of the given model.
+func (v *xsdValidator) checkAttributes(n *xmlNode, m *xsdModel) {
+	decls := make(map[xml.Name]*xsdNode)
+	for _, decl := range m.attributes {
+		name := xml.Name{Local: decl.attr("name")}
+		if ref := decl.attr("ref"); ref != "" {
+			name = decl.qname(ref)
+		} else if decl.attr("form") == "qualified" ||
+			(decl.attr("form") == "" && decl.schema.qualifiedAttributes) {
+			name.Space = decl.schema.targetNS
+		}
+		decls[name] = decl
+	}
+
+	present := make(map[xml.Name]bool)
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") ||
+			a.Name.Space == xsiNamespace {
+			continue
+		}
+		present[a.Name] = true
+		decl := decls[a.Name]
+		if decl == nil {
+			if !m.allowsAttribute(a.Name) {
+				v.fail(n, "the attribute", a.Name.Local, "is not allowed in element",
+					n.name.Local)
+			}
+			continue
+		}
+		if ref := decl.attr("ref"); ref != "" {
+			if decl = v.set.attributes[decl.qname(ref)]; decl == nil {
+				continue
+			}
+		}
+		t := v.set.typeOf(decl)
+		if t == nil {
+			continue
+		}
+		if msg := v.checkSimple(a.Value, t); msg != "" {
+			v.fail(n, "invalid value of attribute", a.Name.Local, "in element",
+				n.name.Local+":", msg)
+		}
+		v.warnUnsupported(n)
+	}
+	for name, decl := range decls {
+		if decl.attr("use") == "required" && !present[name] {
+			v.fail(n, "the required attribute", name.Local, "is missing in element",
+				n.name.Local)
+		}
+	}
+}
+
+// matchOccurs matches the given particle as often as possible against the
+// children starting at the given position. It returns the position after the
+// matched children and false if the particle could not be matched as often
+// as required.
+func (v *xsdValidator) matchOccurs(p *xsdNode, children []*xmlNode, pos int,
+	decls []*xsdNode) (int, bool) {
+	min, max := p.occurs()
+	count := 0
+	for max < 0 || count < max {
+		next, ok := v.matchOnce(p, children, pos, decls)
+		if !ok || next == pos {
+			break
+		}
+		pos = next
+		count++
+	}
+	if count >= min {
+		return pos, true
+	}
+	// an emptiable particle is matched by an empty sequence of children
+	if next, ok := v.matchOnce(p, children, pos, decls); ok && next == pos {
+		return pos, true
+	}
+	return pos, false
+}
+
+// matchOnce matches a single occurrence of the given particle.
+func (v *xsdValidator) matchOnce(p *xsdNode, children []*xmlNode, pos int,
+	decls []*xsdNode) (int, bool) {
+	switch p.elem.Tag {
+	case "element":
+		decl := p
+		name := xml.Name{Local: p.attr("name")}
+		if ref := p.attr("ref"); ref != "" {
+			name = p.qname(ref)
+			if decl = v.set.elements[name]; decl == nil {
+				decl = p
+			}
+		} else if p.attr("form") == "qualified" ||
+			(p.attr("form") == "" && p.schema.qualifiedElements) {
+			name.Space = p.schema.targetNS
+		}
+		if pos < len(children) && children[pos].name == name {
+			decls[pos] = decl
+			return pos + 1, true
+		}
+		v.expect(pos, name.Local)
+		return pos, false
+
+	case "any":
+		if pos < len(children) && matchesWildcard(p, children[pos].name.Space) {
+			decls[pos] = anyDecl
+			return pos + 1, true
+		}
+		v.expect(pos, "any element")
+		return pos, false
+
+	case "sequence":
+		ok := true
+		for _, child := range p.children() {
+			if pos, ok = v.matchOccurs(child, children, pos, decls); !ok {
+				return pos, false
+			}
+		}
+		return pos, true
+
+	case "choice":
+		empty := false
+		for _, child := range p.children() {
+			next, ok := v.matchOccurs(child, children, pos, decls)
+			if ok && next > pos {
+				return next, true
+			}
+			if ok {
+				empty = true
+			}
+		}
+		return pos, empty
+
+	case "all":
+		items := p.children()
+		matched := make([]bool, len(items))
+		for progress := true; progress; {
+			progress = false
+			for i, item := range items {
+				if matched[i] {
+					continue
+				}
+				if next, ok := v.matchOnce(item, children, pos, decls); ok && next > pos {
+					matched[i], pos, progress = true, next, true
+				}
+			}
+		}
+		for i, item := range items {
+			if min, _ := item.occurs(); !matched[i] && min > 0 {
+				return pos, false
+			}
+		}
+		return pos, true
+
+	case "group":
+		group := p
+		if ref := p.attr("ref"); ref != "" {
+			if group = v.set.groups[p.qname(ref)]; group == nil {
+				return pos, true
+			}
+		}
+		items := group.children()
+		if len(items) == 0 {
+			return pos, true
+		}
+		return v.matchOccurs(items[0], children, pos, decls)
+	}
+	return pos, true
+}
+
+// expect records that an element with the given name was expected at the
+// given position.
+func (v *xsdValidator) expect(pos int, name string) {
+	if pos > v.farthest {
+		v.farthest, v.expected = pos, nil
+	}
+	if pos < v.farthest {
+		return
+	}
+	for _, e := range v.expected {
+		if e == name {
+			return
+		}
+	}
+	v.expected = append(v.expected, name)
+}
+
+// matchesWildcard returns true if the namespace constraint of the given
+// wildcard allows elements or attributes of the given namespace.
+func matchesWildcard(any *xsdNode, ns string) bool {
+	constraint := any.attr("namespace")
+	switch constraint {
+	case "", "##any":
+		return true
+	case "##other":
+		return ns != any.schema.targetNS && ns != ""
+	}
+	for _, allowed := range strings.Fields(constraint) {
+		if allowed == ns || (allowed == "##targetNamespace" && ns == any.schema.targetNS) ||
+			(allowed == "##local" && ns == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSimple checks the given value against the given simple type. It
+// returns a message that describes the problem or an empty string if the
+// value is valid.
+func (v *xsdValidator) checkSimple(value string, t *xsdType) string {
+	if t == nil {
+		return ""
+	}
+	if t.builtin != "" {
+		return checkBuiltin(value, t.builtin)
+	}
+	if t.node.elem.Tag != "simpleType" {
+		return ""
+	}
+	for _, child := range t.node.children() {
+		switch child.elem.Tag {
+		case "restriction":
+			return v.checkRestriction(value, child)
+		case "list":
+			itemType := v.inlineOrRef(child, "itemType")
+			for _, item := range strings.Fields(value) {
+				if msg := v.checkSimple(item, itemType); msg != "" {
+					return msg
+				}
+			}
+			return ""
+		case "union":
+			var members []*xsdType
+			for _, name := range strings.Fields(child.attr("memberTypes")) {
+				members = append(members, v.set.resolveType(child.qname(name)))
+			}
+			for _, inline := range child.children() {
+				if inline.elem.Tag == "simpleType" {
+					members = append(members, &xsdType{node: inline})
+				}
+			}
+			msg := ""
+			for _, member := range members {
+				if msg = v.checkSimple(value, member); msg == "" {
+					return ""
+				}
+			}
+			return msg
+		}
+	}
+	return ""
+}
+
+// inlineOrRef returns the type that is referenced by the given attribute of
+// the node or that is defined as anonymous simple type in the node.
+func (v *xsdValidator) inlineOrRef(n *xsdNode, attr string) *xsdType {
+	if name := n.attr(attr); name != "" {
+		return v.set.resolveType(n.qname(name))
+	}
+	if inline := n.child("simpleType"); inline != nil {
+		return &xsdType{node: inline}
+	}
+	return nil
+}
+
+// checkRestriction checks the value against the base type and the facets
+// of the given restriction.
+func (v *xsdValidator) checkRestriction(value string, r *xsdNode) string {
+	base := v.inlineOrRef(r, "base")
+	if msg := v.checkSimple(value, base); msg != "" {
+		return msg
+	}
+	if base == nil || !isStringType(base) {
+		value = strings.TrimSpace(value)
+	}
+	var enumeration, patterns []string
+	for _, facet := range r.children() {
+		f := facet.elem.SelectAttrValue("value", "")
+		switch facet.elem.Tag {
+		case "enumeration":
+			enumeration = append(enumeration, f)
+		case "pattern":
+			patterns = append(patterns, f)
+		case "length", "minLength", "maxLength":
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				continue
+			}
+			l := utf8.RuneCountInString(value)
+			if (facet.elem.Tag == "length" && l != n) ||
+				(facet.elem.Tag == "minLength" && l < n) ||
+				(facet.elem.Tag == "maxLength" && l > n) {
+				return "the length of the value is not valid (" +
+					facet.elem.Tag + " = " + f + ")"
+			}
+		case "minInclusive", "maxInclusive", "minExclusive", "maxExclusive":
+			if !checkBound(value, f, facet.elem.Tag) {
+				return "the value " + value + " is out of range (" +
+					facet.elem.Tag + " = " + f + ")"
+			}
+		}
+	}
+	if len(enumeration) > 0 {
+		found := false
+		for _, e := range enumeration {
+			if e == value || strings.TrimSpace(e) == strings.TrimSpace(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "the value '" + value + "' is not in the enumeration of allowed values"
+		}
+	}
+	if len(patterns) > 0 {
+		matched, unsupported := false, false
+		for _, p := range patterns {
+			re := v.pattern(p)
+			if re == nil {
+				v.unsupported = append(v.unsupported, p)
+				unsupported = true
+				continue
+			}
+			if re.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched && !unsupported {
+			return "the value '" + value + "' does not match the pattern " + patterns[0]
+		}
+	}
+	return ""
+}
+
+// isStringType returns true if the whitespace of values of the given type is
+// preserved.
+func isStringType(t *xsdType) bool {
+	return t.builtin == "string" || t.builtin == "anySimpleType"
+}
+
+// pattern converts the given XSD pattern into a regular expression. It
+// returns nil if the pattern is not supported.
+func (v *xsdValidator) pattern(p string) *regexp.Regexp {
+	if re, ok := v.set.patterns[p]; ok {
+		return re
+	}
+	var re *regexp.Regexp
+	if expr, ok := translatePattern(p); ok {
+		re, _ = regexp.Compile("^(?:" + expr + ")$")
+	}
+	v.set.patterns[p] = re
+	return re
+}
+
+// The multi-character escapes of XSD patterns as Go character classes.
+// Negated escapes are given as negated classes.
+var xsdEscapes = map[rune]string{
+	'd': `[\p{Nd}]`,
+	'D': `[^\p{Nd}]`,
+	's': `[ \t\n\r]`,
+	'S': `[^ \t\n\r]`,
+	'w': `[^\p{P}\p{Z}\p{C}]`,
+	'W': `[\p{P}\p{Z}\p{C}]`,
+	'i': `[\p{L}_:]`,
+	'I': `[^\p{L}_:]`,
+	'c': `[\p{L}\p{M}\p{N}_:.\-\x{B7}]`,
+	'C': `[^\p{L}\p{M}\p{N}_:.\-\x{B7}]`,
+}
+
+// The single-character escapes of XSD patterns.
+var xsdCharEscapes = map[rune]rune{
+	'n': '\n', 'r': '\r', 't': '\t', '\\': '\\', '|': '|', '.': '.',
+	'-': '-', '^': '^', '?': '?', '*': '*', '+': '+', '{': '{', '}': '}',
+	'(': '(', ')': ')', '[': '[', ']': ']',
+}
+
+// translatePattern translates the given XSD pattern into the syntax of Go's
+// regexp package. The character classes of the pattern, including negated
+// escapes and class subtractions like `[a-z-[aeiou]]`, are translated into
+// explicit rune ranges. It returns false if the pattern contains constructs
+// that are not supported, e.g. Unicode block escapes like `\p{IsBasicLatin}`.
+func translatePattern(p string) (string, bool) {
+	t := &patternTranslator{runes: []rune(p)}
+	var buf strings.Builder
+	for t.pos < len(t.runes) {
+		r := t.runes[t.pos]
+		switch r {
+		case '[':
+			ranges, ok := t.class()
+			if !ok {
+				return "", false
+			}
+			buf.WriteString(formatRanges(ranges))
+			continue
+		case '\\':
+			ranges, ok := t.escape()
+			if !ok {
+				return "", false
+			}
+			buf.WriteString(formatRanges(ranges))
+			continue
+		case '.':
+			buf.WriteString(`[^\n\r]`)
+		case '^', '$':
+			// anchors are not special in XSD patterns
+			buf.WriteString(`\` + string(r))
+		default:
+			buf.WriteRune(r)
+		}
+		t.pos++
+	}
+	return buf.String(), true
+}
+
+// patternTranslator holds the state of the translation of an XSD pattern.
+// Character classes are handled as sorted lists of rune ranges `lo, hi, ...`
+// as in the `regexp/syntax` package.
+type patternTranslator struct {
+	runes []rune
+	pos   int
+}
+
+func (t *patternTranslator) peek(offset int) rune {
+	if i := t.pos + offset; i < len(t.runes) {
+		return t.runes[i]
+	}
+	return -1
+}
+
+// escape reads the escape at the current position and returns its rune
+// ranges.
+func (t *patternTranslator) escape() ([]rune, bool) {
+	c := t.peek(1)
+	t.pos += 2
+	if r, ok := xsdCharEscapes[c]; ok {
+		return []rune{r, r}, true
+	}
+	if expr, ok := xsdEscapes[c]; ok {
+		return parseRanges(expr)
+	}
+	if c != 'p' && c != 'P' || t.peek(0) != '{' {
+		return nil, false
+	}
+	end := t.pos
+	for end < len(t.runes) && t.runes[end] != '}' {
+		end++
+	}
+	if end == len(t.runes) {
+		return nil, false
+	}
+	name := string(t.runes[t.pos+1 : end])
+	t.pos = end + 1
+	if strings.HasPrefix(name, "Is") {
+		return nil, false
+	}
+	return parseRanges(`\` + string(c) + "{" + name + "}")
+}
+
+// class reads the character class at the current position, including
+// class subtractions, and returns its rune ranges.
+func (t *patternTranslator) class() ([]rune, bool) {
+	t.pos++
+	negated := t.peek(0) == '^'
+	if negated {
+		t.pos++
+	}
+	var ranges []rune
+	first := true
+	for {
+		c := t.peek(0)
+		switch {
+		case c < 0:
+			return nil, false
+		case c == ']' && !first:
+			t.pos++
+			if negated {
+				ranges = negateRanges(ranges)
+			}
+			return ranges, true
+		case c == '-' && t.peek(1) == '[':
+			t.pos++
+			sub, ok := t.class()
+			if !ok || t.peek(0) != ']' {
+				return nil, false
+			}
+			t.pos++
+			if negated {
+				ranges = negateRanges(ranges)
+			}
+			// a - b == not(not(a) or b)
+			return negateRanges(unionRanges(negateRanges(ranges), sub)), true
+		}
+		first = false
+
+		var lo []rune
+		if c == '\\' {
+			var ok bool
+			if lo, ok = t.escape(); !ok {
+				return nil, false
+			}
+		} else {
+			lo = []rune{c, c}
+			t.pos++
+		}
+
+		// a character range like a-z
+		single := len(lo) == 2 && lo[0] == lo[1]
+		if !single || t.peek(0) != '-' || t.peek(1) == ']' || t.peek(1) == '[' {
+			ranges = unionRanges(ranges, lo)
+			continue
+		}
+		t.pos++
+		c = t.peek(0)
+		var hi []rune
+		if c == '\\' {
+			var ok bool
+			if hi, ok = t.escape(); !ok || len(hi) != 2 || hi[0] != hi[1] {
+				return nil, false
+			}
+		} else if c >= 0 {
+			hi = []rune{c, c}
+			t.pos++
+		} else {
+			return nil, false
+		}
+		if hi[0] < lo[0] {
+			return nil, false
+		}
+		ranges = unionRanges(ranges, []rune{lo[0], hi[0]})
+	}
+}
+
+// parseRanges returns the rune ranges of the given Go character class.
+func parseRanges(expr string) ([]rune, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	switch re.Op {
+	case syntax.OpCharClass:
+		return unionRanges(nil, re.Rune), true
+	case syntax.OpLiteral:
+		if len(re.Rune) == 1 {
+			return []rune{re.Rune[0], re.Rune[0]}, true
+		}
+	case syntax.OpAnyChar:
+		return []rune{0, unicode.MaxRune}, true
+	case syntax.OpAnyCharNotNL:
+		return []rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}, true
+	}
+	return nil, false
+}
+
+// unionRanges returns the sorted and merged union of the given rune ranges.
+func unionRanges(a, b []rune) []rune {
+	var pairs [][2]rune
+	for _, list := range [][]rune{a, b} {
+		for i := 0; i+1 < len(list); i += 2 {
+			pairs = append(pairs, [2]rune{list[i], list[i+1]})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+	var merged []rune
+	for _, p := range pairs {
+		n := len(merged)
+		if n > 0 && p[0] <= merged[n-1]+1 {
+			if p[1] > merged[n-1] {
+				merged[n-1] = p[1]
+			}
+			continue
+		}
+		merged = append(merged, p[0], p[1])
+	}
+	return merged
+}
+
+// negateRanges returns the complement of the given sorted and merged rune
+// ranges.
+func negateRanges(ranges []rune) []rune {
+	var negated []rune
+	next := rune(0)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i] > next {
+			negated = append(negated, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		negated = append(negated, next, unicode.MaxRune)
+	}
+	return negated
+}
+
+// formatRanges formats the given rune ranges as a Go character class.
+func formatRanges(ranges []rune) string {
+	if len(ranges) == 0 {
+		// a class that matches nothing
+		return `[^\x00-\x{10FFFF}]`
+	}
+	var buf strings.Builder
+	buf.WriteByte('[')
+	for i := 0; i+1 < len(ranges); i += 2 {
+		buf.WriteString(fmt.Sprintf(`\x{%X}`, ranges[i]))
+		if ranges[i+1] != ranges[i] {
+			buf.WriteString(fmt.Sprintf(`-\x{%X}`, ranges[i+1]))
+		}
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
+
+func checkBound(value, bound, facet string) bool {
+	x, err1 := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	b, err2 := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	switch facet {
+	case "minInclusive":
+		return x >= b
+	case "maxInclusive":
+		return x <= b
+	case "minExclusive":
+		return x > b
+	default:
+		return x < b
+	}
+}
+
+var (
+	xsdDecimal  = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	xsdInteger  = regexp.MustCompile(`^[+-]?\d+$`)
+	xsdDateTime = regexp.MustCompile(
+		`^-?\d{4,}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?$`)
+	xsdDate  = regexp.MustCompile(`^-?\d{4,}-\d{2}-\d{2}(Z|[+-]\d{2}:\d{2})?$`)
+	xsdGYear = regexp.MustCompile(`^-?\d{4,}(Z|[+-]\d{2}:\d{2})?$`)
+)
+
+// checkBuiltin checks the given value against the built-in XSD type with the
+// given name. Types without lexical constraints are not checked.
+func checkBuiltin(value, builtin string) string {
+	if builtin == "string" || builtin == "anySimpleType" {
+		return ""
+	}
+	v := strings.TrimSpace(value)
+	invalid := "the value '" + v + "' is not a valid " + builtin
+	switch builtin {
+	case "boolean":
+		if v != "true" && v != "false" && v != "1" && v != "0" {
+			return invalid
+		}
+	case "decimal":
+		if !xsdDecimal.MatchString(v) {
+			return invalid
+		}
+	case "double", "float":
+		if v == "INF" || v == "-INF" || v == "NaN" {
+			return ""
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return invalid
+		}
+	case "integer", "nonNegativeInteger", "positiveInteger",
+		"nonPositiveInteger", "negativeInteger":
+		if !xsdInteger.MatchString(v) {
+			return invalid
+		}
+		negative := strings.HasPrefix(v, "-") && strings.Trim(v[1:], "0") != ""
+		zero := strings.Trim(strings.TrimLeft(v, "+-"), "0") == ""
+		if (builtin == "nonNegativeInteger" && negative) ||
+			(builtin == "positiveInteger" && (negative || zero)) ||
+			(builtin == "nonPositiveInteger" && !negative && !zero) ||
+			(builtin == "negativeInteger" && !negative) {
+			return invalid
+		}
+	case "long", "int", "short", "byte":
+		bits := map[string]int{"long": 64, "int": 32, "short": 16, "byte": 8}[builtin]
+		if _, err := strconv.ParseInt(strings.TrimPrefix(v, "+"), 10, bits); err != nil {
+			return invalid
+		}
+	case "unsignedLong", "unsignedInt", "unsignedShort", "unsignedByte":
+		bits := map[string]int{"unsignedLong": 64, "unsignedInt": 32,
+			"unsignedShort": 16, "unsignedByte": 8}[builtin]
+		if _, err := strconv.ParseUint(strings.TrimPrefix(v, "+"), 10, bits); err != nil {
+			return invalid
+		}
+	case "dateTime":
+		if !xsdDateTime.MatchString(v) {
+			return invalid
+		}
+	case "date":
+		if !xsdDate.MatchString(v) {
+			return invalid
+		}
+	case "gYear":
+		if !xsdGYear.MatchString(v) {
+			return invalid
+		}
+	}
+	return ""
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func readTestFile(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "xsd", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testSchemas(t *testing.T, extra map[string]string) *xsdSet {
+	files := map[string][]byte{
+		"ILCD_Common_DataTypes.xsd": readTestFile(t, "ILCD_Common_DataTypes.xsd"),
+		"ILCD_ProcessDataSet.xsd":   readTestFile(t, "ILCD_ProcessDataSet.xsd"),
+	}
+	for name, content := range extra {
+		files[name] = []byte(content)
+	}
+	set, err := loadSchemas(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestValidateProcess(t *testing.T) {
+	set := testSchemas(t, nil)
+	process := string(readTestFile(t, "process.xml"))
+	tests := []struct {
+		name string
+		old  string
+		new  string
+
+		// a part of the expected error message; empty if the data set is
+		// valid
+		err string
+	}{
+		{name: "valid"},
+		{
+			name: "invalid UUID pattern",
+			old:  "<common:UUID>11111111-1111-1111-1111-111111111111",
+			new:  "<common:UUID>11111111-1111-1111-1111-11111111111X",
+			err:  "does not match the pattern",
+		},
+		{
+			name: "invalid enumeration value",
+			old:  "<exchangeDirection>Output</exchangeDirection>\n      <meanAmount>5e0",
+			new:  "<exchangeDirection>output</exchangeDirection>\n      <meanAmount>5e0",
+			err:  "is not in the enumeration",
+		},
+		{
+			name: "invalid number",
+			old:  "<meanAmount>5e0</meanAmount>",
+			new:  "<meanAmount>five</meanAmount>",
+			err:  "is not a valid double",
+		},
+		{
+			name: "missing required element",
+			old:  "<exchangeDirection>Output</exchangeDirection>\n      <meanAmount>5e0",
+			new:  "<meanAmount>5e0",
+			err:  "unexpected element meanAmount in exchange; expected: exchangeDirection",
+		},
+		{
+			name: "wrong order of optional elements",
+			old:  "<location>PL</location>\n      <exchangeDirection>Output</exchangeDirection>",
+			new:  "<exchangeDirection>Output</exchangeDirection>\n      <location>PL</location>",
+			err:  "unexpected element location in exchange",
+		},
+		{
+			name: "incomplete content",
+			old:  "<meanAmount>1.0</meanAmount>\n      <resultingAmount>1.0</resultingAmount>",
+			new:  "",
+			err:  "the content of element exchange is incomplete",
+		},
+		{
+			name: "missing required attribute",
+			old:  `<exchange dataSetInternalID="2">`,
+			new:  `<exchange>`,
+			err:  "the required attribute dataSetInternalID is missing",
+		},
+		{
+			name: "attribute from other namespace",
+			old:  `<exchange dataSetInternalID="2">`,
+			new:  `<exchange dataSetInternalID="2" xmlns:ext="http://example.org" ext:note="x">`,
+		},
+		{
+			name: "attribute not allowed",
+			old:  `<exchange dataSetInternalID="2">`,
+			new:  `<exchange dataSetInternalID="2" note="x">`,
+			err:  "the attribute note is not allowed",
+		},
+		{
+			name: "empty string",
+			old:  `<baseName xml:lang="en">Product A production</baseName>`,
+			new:  `<baseName xml:lang="en"></baseName>`,
+			err:  "the length of the value is not valid",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := process
+			if test.old != "" {
+				if !strings.Contains(data, test.old) {
+					t.Fatal("test data do not contain", test.old)
+				}
+				data = strings.Replace(data, test.old, test.new, 1)
+			}
+			violations, err := set.Validate([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.err == "" {
+				for _, v := range violations {
+					t.Errorf("unexpected violation at %d:%d: %s", v.Line, v.Column,
+						v.Message)
+				}
+				return
+			}
+			found := false
+			for _, v := range violations {
+				if strings.Contains(v.Message, test.err) && !v.Warning {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected a violation with %q; got %d violations",
+					test.err, len(violations))
+				for _, v := range violations {
+					t.Log(v.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUnknownRoot(t *testing.T) {
+	set := testSchemas(t, nil)
+	_, err := set.Validate([]byte(`<flowDataSet xmlns="http://lca.jrc.it/ILCD/Flow"/>`))
+	if err != errNoSchema {
+		t.Errorf("got error %v, want %v", err, errNoSchema)
+	}
+}
+
+func TestValidateUnsupportedPattern(t *testing.T) {
+	// Unicode block escapes like \p{IsBasicLatin} are valid in XSD patterns
+	// but not supported by Go's regexp package
+	set := testSchemas(t, map[string]string{"test.xsd": `<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="http://example.org" elementFormDefault="qualified">
+  <xs:element name="code">
+    <xs:simpleType>
+      <xs:restriction base="xs:string">
+        <xs:pattern value="\p{IsBasicLatin}+"/>
+      </xs:restriction>
+    </xs:simpleType>
+  </xs:element>
+</xs:schema>`})
+	violations, err := set.Validate([]byte(`<code xmlns="http://example.org">ä</code>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || !violations[0].Warning ||
+		!strings.Contains(violations[0].Message, "unsupported pattern") {
+		t.Errorf("expected a warning about an unsupported pattern, got %d violations",
+			len(violations))
+		for _, v := range violations {
+			t.Log(v.Message)
+		}
+	}
+}
+
+func TestTranslatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   []string
+		invalid []string
+	}{
+		{`\d{2}\.\d{2}`, []string{"01.00", "٠١.٠٢"}, []string{"1.00", "ab.cd", "01x00"}},
+		{`\D+`, []string{"ab"}, []string{"a1"}},
+		{`\s*x\S`, []string{" \txy"}, []string{"x ", " xy"}},
+		{`\w+`, []string{"aÄ1"}, []string{"a b", "a-b"}},
+		{`\i\c*`, []string{"_a.b-c", "x"}, []string{"1a", "a b"}},
+		{`[a-z-[aeiou]]+`, []string{"bcd"}, []string{"bad", "B"}},
+		{`[\p{L}-[\p{Lu}]]`, []string{"a", "ä"}, []string{"A", "1"}},
+		{`[^\d\s]`, []string{"a", "-"}, []string{"1", " "}},
+		{`[\S-[a-c]]`, []string{"d"}, []string{"a", " "}},
+		{`[-+]?[0-9]+`, []string{"-1", "+2", "3"}, []string{"--1"}},
+		{`[a\-z]`, []string{"a", "-", "z"}, []string{"b"}},
+		{`a.c`, []string{"abc"}, []string{"a\nc", "a\rc"}},
+		{`\^a$`, []string{"^a$"}, []string{"a"}},
+	}
+	for _, test := range tests {
+		expr, ok := translatePattern(test.pattern)
+		if !ok {
+			t.Errorf("pattern %s is not supported", test.pattern)
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			t.Errorf("failed to compile pattern %s: %v", test.pattern, err)
+			continue
+		}
+		for _, s := range test.valid {
+			if !re.MatchString(s) {
+				t.Errorf("pattern %s should match %q", test.pattern, s)
+			}
+		}
+		for _, s := range test.invalid {
+			if re.MatchString(s) {
+				t.Errorf("pattern %s should not match %q", test.pattern, s)
+			}
+		}
+	}
+	for _, p := range []string{`\p{IsBasicLatin}`, `[a-`, `\q`, `[z-a]`} {
+		if _, ok := translatePattern(p); ok {
+			t.Errorf("pattern %s should not be supported", p)
+		}
+	}
+}